@@ -7,10 +7,18 @@ import (
 )
 
 type AddCommand struct {
-	Name     string `short:"n" long:"name" description:"Name of environment variable." required:"yes"`
-	File     string `short:"f" long:"file" description:"File with contents of variable"`
-	Exposed  string `short:"e" long:"exposed" description:"Name of exposed variable, if different than the name."`
-	Multiple bool   `short:"m" long:"multiple" description:"Add multiple variables after the first."`
+	Name     string   `short:"n" long:"name" description:"Name of environment variable." required:"yes"`
+	File     string   `short:"f" long:"file" description:"File with contents of variable"`
+	Stdin    bool     `long:"stdin" description:"Read the value from stdin."`
+	FromEnv  string   `long:"value-from-env" description:"Take the value from this environment variable."`
+	Keys     []string `short:"k" long:"key" description:"Add a variable as KEY=@file or KEY=- (stdin), can be repeated."`
+	Exposed  string   `short:"e" long:"exposed" description:"Name of exposed variable, if different than the name."`
+	Multiple bool     `short:"m" long:"multiple" description:"Add multiple variables after the first."`
+	Echo     bool     `long:"echo" description:"Show values as they are typed."`
+	Confirm  bool     `short:"c" long:"confirm" description:"Enter values twice to confirm them."`
+	Raw      bool     `long:"raw" description:"Store values exactly, without trimming whitespace."`
+	Desc     string   `short:"d" long:"description" description:"Describe what the variable is for."`
+	Tags     []string `short:"t" long:"tag" description:"Tag the variable, can be repeated."`
 }
 
 var addCommand AddCommand
@@ -21,7 +29,21 @@ func (c *AddCommand) Execute(args []string) error {
 		return errors.Wrap(err, "unable to create env box")
 	}
 
-	return box.AddVariable(c.Name, c.Exposed, c.File, c.Multiple)
+	valueOpts := ValueOptions{
+		File:    c.File,
+		Stdin:   c.Stdin,
+		FromEnv: c.FromEnv,
+		Echo:    c.Echo,
+		Confirm: c.Confirm,
+		Raw:     c.Raw,
+	}
+
+	meta := VarMetadata{
+		Description: c.Desc,
+		Tags:        c.Tags,
+	}
+
+	return box.AddVariable(c.Name, c.Exposed, valueOpts, meta, c.Keys, c.Multiple)
 }
 
 func init() {
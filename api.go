@@ -2,18 +2,20 @@ package main
 
 import (
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/nacl/secretbox"
 )
 
 type EnvVar struct {
@@ -24,25 +26,191 @@ type EnvVar struct {
 	// LegacyExposed is the name of the variable to expose.  Early versions of
 	// envbox only supported one variable per name, and this is where it was
 	// stored.  When the old format is loaded, this is moved to the Vars map
-	LegacyExposed string `json:"exposed"`
+	LegacyExposed string `json:"exposed,omitempty"`
 
 	// LegacyValue is the value of the variable.  Early versions of envbox only
 	// supported one variable per name, and this is where its value was stored.
 	// When the old format is loaded, this is moved to the Vars map
-	LegacyValue string `json:"value"`
+	LegacyValue string `json:"value,omitempty"`
 
 	// Path is the name of the underlying file that the data is stored in.  It
 	// isn't present in the JSON data.
 	Path string `json:"-"`
 
+	// ModTime is when the underlying file was last written.  It isn't present
+	// in the JSON data.
+	ModTime time.Time `json:"-"`
+
 	// Vars holds the key/value pairs to expose as environment variables when
 	// running commands.
 	Vars map[string]string
+
+	// Encodings records, by key, how values in Vars are encoded.  Values added
+	// with --raw are base64 encoded so that any bytes are kept exactly.
+	Encodings map[string]string `json:"encodings,omitempty"`
+
+	// Tags are labels used to find and select groups.
+	Tags []string `json:"tags,omitempty"`
+
+	// Description says what the group is for.
+	Description string `json:"description,omitempty"`
+
+	// Created and Updated are when the group was added and last changed.
+	// Groups stored by older versions of envbox don't have them.
+	Created *time.Time `json:"created,omitempty"`
+	Updated *time.Time `json:"updated,omitempty"`
+
+	// Source is how values were last entered, such as sourcePrompt, or
+	// sourceMixed if they were entered in more than one way at once.
+	Source string `json:"source,omitempty"`
+}
+
+const encodingBase64 = "base64"
+
+// Sources of values, recorded in EnvVar.Source.
+const (
+	sourceFile   = "file"
+	sourcePrompt = "prompt"
+	sourceStdin  = "stdin"
+	sourceEnv    = "env"
+	sourceImport = "import"
+	sourceMixed  = "mixed"
+)
+
+// combineSources returns the source that values entered together came from,
+// which is sourceMixed unless they all came from the same place.
+func combineSources(sources ...string) string {
+	if len(sources) == 0 {
+		return ""
+	}
+	for _, source := range sources[1:] {
+		if source != sources[0] {
+			return sourceMixed
+		}
+	}
+	return sources[0]
+}
+
+// touch records that the group was changed now, with values from source if
+// it's set.
+func (ev *EnvVar) touch(source string) {
+	now := time.Now().UTC()
+	ev.Updated = &now
+	if len(source) > 0 {
+		ev.Source = source
+	}
+}
+
+// VarMetadata describes a variable group.  Empty fields are left unchanged.
+type VarMetadata struct {
+	Description string
+
+	// Tags are added to the group, and Untag removed from it.
+	Tags  []string
+	Untag []string
+}
+
+func (meta VarMetadata) isSet() bool {
+	return len(meta.Description) > 0 || len(meta.Tags) > 0 || len(meta.Untag) > 0
+}
+
+// validate checks the metadata before anything is prompted for, so a typo
+// doesn't throw away a value that's just been entered.
+func (meta VarMetadata) validate() error {
+	if strings.ContainsAny(meta.Description, "\r\n") {
+		return fmt.Errorf("description can't contain newlines")
+	}
+	for _, tag := range append(append([]string{}, meta.Tags...), meta.Untag...) {
+		if len(tag) == 0 || strings.ContainsAny(tag, ", \t\n") {
+			return fmt.Errorf("invalid tag %q, tags can't be empty or contain commas or spaces", tag)
+		}
+	}
+	return nil
+}
+
+// apply updates envVar with the metadata, which has been validated.
+func (meta VarMetadata) apply(envVar *EnvVar) error {
+	if len(meta.Description) > 0 {
+		envVar.Description = meta.Description
+	}
+
+	for _, tag := range meta.Untag {
+		if !hasTag(*envVar, tag) {
+			return fmt.Errorf("variable %s has no tag %s", envVar.Name, tag)
+		}
+		var tags []string
+		for _, t := range envVar.Tags {
+			if t != tag {
+				tags = append(tags, t)
+			}
+		}
+		envVar.Tags = tags
+	}
+
+	for _, tag := range meta.Tags {
+		if !hasTag(*envVar, tag) {
+			envVar.Tags = append(envVar.Tags, tag)
+		}
+	}
+
+	return nil
+}
+
+// SetValue stores a value in Vars, base64 encoding it if raw is set.
+func (ev *EnvVar) SetValue(k, value string, raw bool) {
+	if ev.Vars == nil {
+		ev.Vars = make(map[string]string)
+	}
+
+	if raw {
+		if ev.Encodings == nil {
+			ev.Encodings = make(map[string]string)
+		}
+		ev.Vars[k] = base64.StdEncoding.EncodeToString([]byte(value))
+		ev.Encodings[k] = encodingBase64
+	} else {
+		ev.Vars[k] = value
+		ev.deleteEncoding(k)
+	}
+}
+
+// DeleteValue removes a value from Vars.
+func (ev *EnvVar) DeleteValue(k string) {
+	delete(ev.Vars, k)
+	ev.deleteEncoding(k)
+}
+
+func (ev *EnvVar) deleteEncoding(k string) {
+	delete(ev.Encodings, k)
+	if len(ev.Encodings) == 0 {
+		ev.Encodings = nil
+	}
+}
+
+// Values returns the values in Vars, decoded.
+func (ev EnvVar) Values() (map[string]string, error) {
+	values := make(map[string]string)
+	for k, v := range ev.Vars {
+		switch ev.Encodings[k] {
+		case "":
+			values[k] = v
+		case encodingBase64:
+			decoded, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to decode %s", k)
+			}
+			values[k] = string(decoded)
+		default:
+			return nil, fmt.Errorf("unknown encoding %s for %s", ev.Encodings[k], k)
+		}
+	}
+	return values, nil
 }
 
 type EnvBox struct {
 	System
 	Prompter
+	io.Reader
 	io.Writer
 	// Config
 }
@@ -51,11 +219,72 @@ func NewEnvBox() (*EnvBox, error) {
 	return &EnvBox{
 		System:   &DefaultSystem{},
 		Prompter: &DefaultPrompter{},
+		Reader:   os.Stdin,
 		Writer:   os.Stdout,
 	}, nil
 }
 
-func (box *EnvBox) AddVariable(name, exposed, file string, multiple bool) error {
+// ValueOptions controls how values are read when adding or setting variables.
+type ValueOptions struct {
+	// File to read the value from, instead of prompting.
+	File string
+
+	// Stdin reads the value from standard input, instead of prompting.
+	Stdin bool
+
+	// FromEnv is an environment variable to take the value from, instead of
+	// prompting.
+	FromEnv string
+
+	// Echo shows values as they're typed, instead of masking them.
+	Echo bool
+
+	// Confirm prompts for values twice, to guard against typos.
+	Confirm bool
+
+	// Raw keeps values exactly as read, instead of trimming whitespace, and
+	// stores them base64 encoded so binary data survives.
+	Raw bool
+}
+
+// trim removes surrounding whitespace from values read from files or stdin,
+// unless Raw is set.
+func (vo ValueOptions) trim(value string) string {
+	if vo.Raw {
+		return value
+	}
+	return strings.TrimSpace(value)
+}
+
+// hasSource reports whether a value source other than prompting was chosen.
+func (vo ValueOptions) hasSource() bool {
+	return len(vo.File) > 0 || vo.Stdin || len(vo.FromEnv) > 0
+}
+
+// source returns where values read with these options come from.
+func (vo ValueOptions) source() string {
+	switch {
+	case len(vo.File) > 0:
+		return sourceFile
+	case vo.Stdin:
+		return sourceStdin
+	case len(vo.FromEnv) > 0:
+		return sourceEnv
+	}
+	return sourcePrompt
+}
+
+// AddVariable adds a new variable group.  Its variables either come from
+// pairs, in the form KEY=@file or KEY=- for stdin, or are a single variable
+// read according to valueOpts, optionally followed by more that are prompted
+// for.
+func (box *EnvBox) AddVariable(name, exposed string, valueOpts ValueOptions, meta VarMetadata, pairs []string, multiple bool) error {
+	if err := meta.validate(); err != nil {
+		return err
+	}
+	if len(meta.Untag) > 0 {
+		return fmt.Errorf("a new variable has no tags to remove")
+	}
 
 	var err error
 
@@ -74,334 +303,1484 @@ func (box *EnvBox) AddVariable(name, exposed, file string, multiple bool) error
 		return fmt.Errorf("var %s already exists", name)
 	}
 
-	if len(exposed) == 0 {
-		exposed = name
-	}
+	var newVars map[string]string
+	source := valueOpts.source()
+	if len(pairs) > 0 {
+		if len(exposed) > 0 || multiple || valueOpts.hasSource() {
+			return fmt.Errorf("keys can't be combined with exposed, multiple, file, stdin or env options")
+		}
 
-	var value string
-	if len(file) > 0 {
-		data, err := ioutil.ReadFile(file)
+		newVars, source, err = box.readPairs(pairs, valueOpts)
 		if err != nil {
-			return errors.Wrap(err, "error reading file")
+			return err
 		}
-		value = strings.TrimSpace(string(data))
 	} else {
-		value, err = box.PromptFor("value: ")
+		if len(exposed) == 0 {
+			exposed = name
+		}
+		if err := checkExposedName(exposed); err != nil {
+			return err
+		}
+
+		value, err := box.readValue(valueOpts)
 		if err != nil {
-			return errors.Wrap(err, "error reading value")
+			return err
 		}
-	}
 
-	newVars := map[string]string{exposed: value}
+		newVars = map[string]string{exposed: value}
 
-	if multiple {
+		if multiple {
 
-		fmt.Fprintf(box.Writer, "enter additional variables; emtpy name to finish\n")
+			fmt.Fprintf(box.Writer, "enter additional variables; emtpy name to finish\n")
 
-		for {
-			varName, err := box.PromptFor("name: ")
-			if err != nil {
-				return errors.Wrap(err, "error reading name")
-			}
-			if len(varName) == 0 {
-				break
-			}
+			for {
+				varName, err := box.PromptFor("name: ")
+				if err != nil {
+					return errors.Wrap(err, "error reading name")
+				}
+				if len(varName) == 0 {
+					break
+				}
+				if err := checkExposedName(varName); err != nil {
+					return err
+				}
 
-			varValue, err := box.PromptFor("value: ")
-			if err != nil {
-				return errors.Wrap(err, "error reading value")
-			}
+				varValue, err := box.promptValue("value: ", valueOpts)
+				if err != nil {
+					return errors.Wrap(err, "error reading value")
+				}
 
-			newVars[varName] = varValue
+				newVars[varName] = varValue
+				source = combineSources(source, sourcePrompt)
+			}
 		}
 	}
 
-	message, err := json.Marshal(EnvVar{Name: name, Vars: newVars})
+	unlock, err := box.lockData()
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	var keyBytes [32]byte
-	copy(keyBytes[:], []byte(key)[:32])
-
-	var nonce [24]byte
-	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
-		panic(err)
+	// check again, in case it was added while prompting
+	vars, err = box.LoadEnvVars(key)
+	if err != nil {
+		return errors.Wrap(err, "unable to load vars")
 	}
 
-	out := make([]byte, 24)
-	copy(out, nonce[:])
-
-	out = secretbox.Seal(out, message, &nonce, &keyBytes)
-
-	var fname [24]byte
-	if _, err := io.ReadFull(rand.Reader, fname[:]); err != nil {
-		return errors.Wrap(err, "unable to read random")
+	if _, ok := vars[name]; ok {
+		return fmt.Errorf("var %s already exists", name)
 	}
 
-	dataPath, err := box.DataPath()
-	if err != nil {
+	envVar := EnvVar{Name: name}
+	for k, v := range newVars {
+		envVar.SetValue(k, v, valueOpts.Raw)
+	}
+	if err := meta.apply(&envVar); err != nil {
 		return err
 	}
+	envVar.touch(source)
+	envVar.Created = envVar.Updated
 
-	return ioutil.WriteFile(filepath.Join(dataPath, fmt.Sprintf("%s.envenc", hex.EncodeToString(fname[:]))), out, 0600)
+	return box.writeEnvVar(key, &envVar)
 }
 
-func (box *EnvBox) keyPath() (string, error) {
-	dataPath, err := box.DataPath()
-	if err != nil {
-		return "", err
+// SetVariable changes or adds a single exposed variable in an existing
+// variable group and re-seals it into the same file.  Without an exposed
+// name, the group's only key, or the key with the group's name, is changed; a
+// new key is only added when it's named.  If only metadata is given, with no
+// exposed name or value source, just the metadata is changed.
+func (box *EnvBox) SetVariable(name, exposed string, valueOpts ValueOptions, meta VarMetadata) error {
+	if err := meta.validate(); err != nil {
+		return err
 	}
 
-	return filepath.Join(dataPath, "secret.key"), nil
-}
+	if meta.isSet() && len(exposed) == 0 && !valueOpts.hasSource() {
+		return box.updateVariable(name, nil, func(envVar *EnvVar) error {
+			envVar.touch("")
+			return meta.apply(envVar)
+		})
+	}
 
-func (box *EnvBox) ReadKey() (string, error) {
-	keyPath, err := box.keyPath()
-	if err != nil {
-		return "", errors.Wrap(err, "unable to get key path")
+	named := len(exposed) > 0
+	if named {
+		if err := checkExposedName(exposed); err != nil {
+			return err
+		}
 	}
 
-	var key string
+	var value string
+	readValue := func(envVar EnvVar) error {
+		var err error
+		if !named {
+			if exposed, err = defaultKey(envVar); err != nil {
+				return err
+			}
+		}
 
-	if helperKey, _ := GetCredHelperKey(); len(helperKey) > 0 {
-		logrus.Debugf("found cred helper key, using that")
-		key = helperKey
-	} else if pathKeyData, err := ioutil.ReadFile(keyPath); err == nil {
-		logrus.Debugf("found file key, using that")
-		key = strings.TrimSpace(string(pathKeyData))
+		value, err = box.readValue(valueOpts)
+		return err
 	}
 
-	if len(key) == 0 {
-		promptedKey, err := box.PromptForKey()
-		if err != nil {
-			return "", errors.Wrap(err, "unable prompt for key")
+	return box.updateVariable(name, readValue, func(envVar *EnvVar) error {
+		if _, ok := envVar.Vars[exposed]; !ok && !named {
+			return fmt.Errorf("variable %s no longer has key %s", name, exposed)
 		}
 
-		err = box.StoreKey(promptedKey)
-		if err != nil {
-			return "", errors.Wrap(err, "unable to set key")
-		}
+		envVar.SetValue(exposed, value, valueOpts.Raw)
+		envVar.touch(valueOpts.source())
 
-		key = promptedKey
-	}
+		return meta.apply(envVar)
+	})
+}
 
-	return key, nil
+// defaultKey picks the key that set changes when none is named: the group's
+// only key, or else the one with the group's name.
+func defaultKey(envVar EnvVar) (string, error) {
+	if len(envVar.Vars) == 1 {
+		for k := range envVar.Vars {
+			return k, nil
+		}
+	}
+	if _, ok := envVar.Vars[envVar.Name]; ok {
+		return envVar.Name, nil
+	}
+	return "", fmt.Errorf("variable %s has keys %s, use -e to choose one", envVar.Name, strings.Join(sortedKeys(envVar.Vars), ", "))
 }
 
-func (box *EnvBox) PromptForKey() (string, error) {
+// UnsetVariable removes exposed variables from a variable group.  The last
+// variable in a group can't be removed; use RemoveVariable for that.
+func (box *EnvBox) UnsetVariable(name string, exposed []string) error {
+	return box.updateVariable(name, nil, func(envVar *EnvVar) error {
+		// a key given more than once is only removed once
+		remove := make(map[string]bool)
+		for _, k := range exposed {
+			if _, ok := envVar.Vars[k]; !ok {
+				return fmt.Errorf("variable %s has no key %s", name, k)
+			}
+			remove[k] = true
+		}
 
-	key, err := box.PromptMasked("enter key: ")
-	if err != nil {
-		return "", errors.Wrap(err, "unable to prompt for key")
-	}
+		if len(remove) >= len(envVar.Vars) {
+			return fmt.Errorf("unable to remove all keys from %s, remove the variable instead", name)
+		}
 
-	// TODO: check that key is valid
+		for k := range remove {
+			envVar.DeleteValue(k)
+		}
+		envVar.touch("")
 
-	return key, nil
+		return nil
+	})
 }
 
-func (box *EnvBox) StoreKey(key string) error {
+// RenameKey changes the name of one exposed variable in a variable group.
+func (box *EnvBox) RenameKey(name, oldExposed, newExposed string) error {
+	return box.updateVariable(name, nil, func(envVar *EnvVar) error {
+		value, ok := envVar.Vars[oldExposed]
+		if !ok {
+			return fmt.Errorf("variable %s has no key %s", name, oldExposed)
+		}
+		if err := checkExposedName(newExposed); err != nil {
+			return err
+		}
+		if _, ok := envVar.Vars[newExposed]; ok {
+			return fmt.Errorf("variable %s already has key %s", name, newExposed)
+		}
+
+		encoding := envVar.Encodings[oldExposed]
+		envVar.DeleteValue(oldExposed)
+		envVar.Vars[newExposed] = value
+		if len(encoding) > 0 {
+			if envVar.Encodings == nil {
+				envVar.Encodings = make(map[string]string)
+			}
+			envVar.Encodings[newExposed] = encoding
+		}
+		envVar.touch("")
 
-	err := StoreCredHelperKey(key)
-	if err == nil {
-		logrus.Debugf("helper key stored")
 		return nil
-	} else if err != nil && err != helperNotFound {
-		return errors.Wrap(err, "unable to set with helper")
-	}
+	})
+}
 
-	logrus.Debugf("falling back on path based storage")
-	keyPath, err := box.keyPath()
+// RenameVariable changes the name that a variable group is referred to by.
+func (box *EnvBox) RenameVariable(oldName, newName string) error {
+	key, err := box.ReadKey()
 	if err != nil {
-		return errors.Wrap(err, "unable to get key path")
+		return errors.Wrap(err, "unable to read key")
 	}
 
-	return ioutil.WriteFile(keyPath, []byte(key), 0600)
-}
-
-func (box *EnvBox) ClearKey() error {
-	err := ClearCredHelperKey()
-	if err == nil {
-		logrus.Debugf("helper key cleared")
-	} else if err != nil && err != helperNotFound {
-		return errors.Wrap(err, "unable to clear cred helper key")
+	unlock, err := box.lockData()
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	logrus.Debugf("clearing out path based storage")
-	keyPath, err := box.keyPath()
+	vars, err := box.LoadEnvVars(key)
 	if err != nil {
-		return errors.Wrap(err, "unable to get key path")
+		return errors.Wrap(err, "unable to load env vars")
 	}
-	return os.Remove(keyPath)
-}
 
-func (box *EnvBox) LoadEnvVars(key string) (map[string]EnvVar, error) {
-	vars := make(map[string]EnvVar)
-
-	dataPath, err := box.DataPath()
-	if err != nil {
-		return vars, errors.Wrap(err, "unable to get data path")
+	envVar, ok := vars[oldName]
+	if !ok {
+		return fmt.Errorf("variable %s not found", oldName)
 	}
-	files, err := ioutil.ReadDir(dataPath)
-	if err != nil {
-		return vars, errors.Wrap(err, "unable to read directory")
+	if _, ok := vars[newName]; ok {
+		return fmt.Errorf("var %s already exists", newName)
 	}
 
-	var keyBytes [32]byte
-	copy(keyBytes[:], []byte(key)[:32])
-
-	for _, info := range files {
-		if !info.IsDir() && strings.HasSuffix(info.Name(), ".envenc") {
-			fileName := filepath.Join(dataPath, info.Name())
-
-			data, err := ioutil.ReadFile(fileName)
-			if err != nil {
-				return vars, errors.Wrap(err, "unable to read file")
-			}
-
-			nonce := new([24]byte)
-			copy(nonce[:], data[:24])
-
-			if message, ok := secretbox.Open(nil, data[24:], nonce, &keyBytes); ok {
-
-				var envVar EnvVar
-				err := json.Unmarshal(message, &envVar)
-				if err != nil {
-					// ignore
-				}
-				envVar.Path = fileName
-
-				if len(envVar.LegacyExposed) > 0 {
-					envVar.Vars = map[string]string{envVar.LegacyExposed: envVar.LegacyValue}
-				}
+	envVar.Name = newName
+	envVar.touch("")
 
-				vars[envVar.Name] = envVar
-			} else {
-				// ignore
-			}
+	return box.writeEnvVar(key, &envVar)
+}
 
-		}
+// ImportDotenv reads variables from a .env file into a variable group,
+// creating the group if it doesn't exist.  Existing keys in the group are
+// overwritten by those in the file.  With shred set, the file is overwritten
+// and removed once the variables are stored.
+func (box *EnvBox) ImportDotenv(name, file string, shred bool) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.Wrap(err, "error reading file")
 	}
 
-	return vars, nil
-}
+	imported, err := parseDotenv(string(data))
+	if err != nil {
+		return errors.Wrapf(err, "unable to parse %s", file)
+	}
+	if len(imported) == 0 {
+		return fmt.Errorf("no variables found in %s", file)
+	}
 
-func (box *EnvBox) ListVariables() error {
 	key, err := box.ReadKey()
 	if err != nil {
 		return errors.Wrap(err, "unable to read key")
 	}
 
+	unlock, err := box.lockData()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	vars, err := box.LoadEnvVars(key)
 	if err != nil {
 		return errors.Wrap(err, "unable to load vars")
 	}
 
-	for name, envVar := range vars {
-		// TODO: figure out a better way to list these
-		fmt.Fprintf(box.Writer, name)
-		fmt.Fprintf(box.Writer, ": ")
-
-		varNames := []string{}
-		for k, _ := range envVar.Vars {
-			varNames = append(varNames, fmt.Sprintf("%s", k))
-		}
-
-		fmt.Fprintf(box.Writer, "%s", strings.Join(varNames, ", "))
-		fmt.Fprintf(box.Writer, "\n")
+	envVar, ok := vars[name]
+	if !ok {
+		envVar = EnvVar{Name: name}
 	}
 
-	return nil
-}
+	for k, v := range imported {
+		envVar.SetValue(k, v, false)
+	}
+	envVar.touch(sourceImport)
+	if !ok {
+		envVar.Created = envVar.Updated
+	}
 
-func (box *EnvBox) GenerateNewKey(set bool) error {
-	var pass [32]byte
-	if _, err := io.ReadFull(rand.Reader, pass[:]); err != nil {
-		return errors.Wrap(err, "unable to read random")
+	if err := box.writeEnvVar(key, &envVar); err != nil {
+		return err
 	}
 
-	key := hex.EncodeToString(pass[:])
-	fmt.Fprintf(box.Writer, "%s\n", key)
+	fmt.Fprintf(box.Writer, "imported %d variable(s) into %s\n", len(imported), name)
 
-	if set {
-		// TODO: warn when overriding existing key
-		return box.StoreKey(key)
+	if shred {
+		if err := shredFile(file); err != nil {
+			return errors.Wrapf(err, "unable to shred %s", file)
+		}
 	}
+
 	return nil
 }
 
-func (box *EnvBox) PromptAndStoreKey() error {
-	key, err := box.PromptForKey()
+// shredFile overwrites a file with random data before removing it.  This is
+// best effort, as filesystems may keep copies of the original blocks.
+func shredFile(file string) error {
+	info, err := os.Stat(file)
 	if err != nil {
-		return errors.Wrap(err, "unable to prompt for key")
+		return err
 	}
 
-	return box.StoreKey(key)
-}
-
-func (box *EnvBox) ShowKey() error {
-	key, err := box.ReadKey()
+	f, err := os.OpenFile(file, os.O_WRONLY, 0)
 	if err != nil {
-		return errors.Wrap(err, "unable to read key")
+		return err
 	}
 
-	fmt.Fprintf(box.Writer, "%s\n", key)
+	if _, err := io.CopyN(f, rand.Reader, info.Size()); err != nil {
+		f.Close()
+		return err
+	}
 
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(file)
+}
+
+// readValue reads a variable's value from a file, stdin or the environment,
+// if one of those is chosen, or prompts the user for it.
+func (box *EnvBox) readValue(valueOpts ValueOptions) (string, error) {
+	sources := 0
+	for _, chosen := range []bool{len(valueOpts.File) > 0, valueOpts.Stdin, len(valueOpts.FromEnv) > 0} {
+		if chosen {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return "", fmt.Errorf("only one of file, stdin and env can be used")
+	}
+
+	if len(valueOpts.File) > 0 {
+		data, err := ioutil.ReadFile(valueOpts.File)
+		if err != nil {
+			return "", errors.Wrap(err, "error reading file")
+		}
+		return valueOpts.trim(string(data)), nil
+	}
+
+	if valueOpts.Stdin {
+		data, err := ioutil.ReadAll(box.Reader)
+		if err != nil {
+			return "", errors.Wrap(err, "error reading stdin")
+		}
+		return valueOpts.trim(string(data)), nil
+	}
+
+	if len(valueOpts.FromEnv) > 0 {
+		value := box.Getenv(valueOpts.FromEnv)
+		if len(value) == 0 {
+			return "", fmt.Errorf("environment variable %s is not set", valueOpts.FromEnv)
+		}
+		return value, nil
+	}
+
+	return box.promptValue("value: ", valueOpts)
+}
+
+// checkExposedName makes sure a new key can be used as an environment
+// variable name, and so exported in every format.
+func checkExposedName(k string) error {
+	if !envNamePattern.MatchString(k) {
+		return fmt.Errorf("%q is not a valid variable name", k)
+	}
+	return nil
+}
+
+// readPairs reads variables given as KEY=@file, to read the value from a
+// file, or KEY=-, to read it from stdin, and returns them along with where
+// they came from.  Literal values aren't accepted, to keep them out of shell
+// history.
+func (box *EnvBox) readPairs(pairs []string, valueOpts ValueOptions) (map[string]string, string, error) {
+	vars := make(map[string]string)
+	var sources []string
+	usedStdin := false
+
+	for _, pair := range pairs {
+		eq := strings.IndexByte(pair, '=')
+		if eq <= 0 {
+			return nil, "", fmt.Errorf("invalid key %q, expected KEY=@file or KEY=-", pair)
+		}
+
+		k, source := pair[:eq], pair[eq+1:]
+		if err := checkExposedName(k); err != nil {
+			return nil, "", err
+		}
+		if _, ok := vars[k]; ok {
+			return nil, "", fmt.Errorf("key %s given more than once", k)
+		}
+
+		pairOpts := valueOpts
+		switch {
+		case source == "-":
+			if usedStdin {
+				return nil, "", fmt.Errorf("only one key can be read from stdin")
+			}
+			usedStdin = true
+			pairOpts.Stdin = true
+		case strings.HasPrefix(source, "@"):
+			pairOpts.File = source[1:]
+		default:
+			return nil, "", fmt.Errorf("literal value given for %s, use %s=@file or %s=- instead", k, k, k)
+		}
+
+		value, err := box.readValue(pairOpts)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "unable to read %s", k)
+		}
+		vars[k] = value
+		sources = append(sources, pairOpts.source())
+	}
+
+	return vars, combineSources(sources...), nil
+}
+
+// promptValue prompts for a value, masked unless Echo is set and twice if
+// Confirm is set.
+func (box *EnvBox) promptValue(prompt string, valueOpts ValueOptions) (string, error) {
+	var value string
+	var err error
+
+	masked := !valueOpts.Echo
+	if valueOpts.Confirm {
+		value, err = box.PromptConfirmed(prompt, masked)
+	} else if masked {
+		value, err = box.PromptMasked(prompt)
+	} else {
+		value, err = box.PromptFor(prompt)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "error reading value")
+	}
+
+	return value, nil
+}
+
+// updateVariable loads the named variable group, lets fun modify it and then
+// writes it back to the file it was loaded from.  The data directory is locked
+// throughout, so fun shouldn't prompt.  Anything that does, like reading a
+// value, goes in prepare, which runs before locking once the group is known to
+// exist; the group is then loaded again, in case it changed in the meantime.
+func (box *EnvBox) updateVariable(name string, prepare func(EnvVar) error, fun func(*EnvVar) error) error {
+	key, err := box.ReadKey()
+	if err != nil {
+		return errors.Wrap(err, "unable to read key")
+	}
+
+	if prepare != nil {
+		envVar, err := box.findVariable(key, name)
+		if err != nil {
+			return err
+		}
+		if err := prepare(envVar); err != nil {
+			return err
+		}
+	}
+
+	unlock, err := box.lockData()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	envVar, err := box.findVariable(key, name)
+	if err != nil {
+		return err
+	}
+
+	if err := fun(&envVar); err != nil {
+		return err
+	}
+
+	return box.writeEnvVar(key, &envVar)
+}
+
+// writeEnvVar seals the EnvVar and writes it to its Path.  If the Path is
+// empty, a new randomly named file is created in the data directory and Path
+// is set to it.
+func (box *EnvBox) writeEnvVar(key string, envVar *EnvVar) error {
+	out, err := sealEnvVar(key, *envVar)
+	if err != nil {
+		return errors.Wrap(err, "unable to seal data")
+	}
+
+	if len(envVar.Path) == 0 {
+		var fname [24]byte
+		if _, err := io.ReadFull(rand.Reader, fname[:]); err != nil {
+			return errors.Wrap(err, "unable to read random")
+		}
+
+		dataPath, err := box.DataPath()
+		if err != nil {
+			return err
+		}
+
+		envVar.Path = filepath.Join(dataPath, fmt.Sprintf("%s.envenc", hex.EncodeToString(fname[:])))
+	}
+
+	return writeFileAtomic(envVar.Path, out, 0600)
+}
+
+// writeFileAtomic writes data to a temporary file next to path, syncs it and
+// then renames it into place, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".envbox-tmp-")
+	if err != nil {
+		return errors.Wrap(err, "unable to create temp file")
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "unable to write temp file")
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "unable to sync temp file")
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "unable to close temp file")
+	}
+
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "unable to set permissions")
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "unable to rename temp file")
+	}
+
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return errors.Wrap(err, "unable to sync directory")
+	}
+
+	return nil
+}
+
+// lockData takes an exclusive lock on the data directory, for operations that
+// read, modify and write data files.  Call the returned function to release
+// it.
+func (box *EnvBox) lockData() (func(), error) {
+	dataPath, err := box.DataPath()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get data path")
+	}
+
+	unlock, err := lockFile(filepath.Join(dataPath, ".lock"))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to lock data directory")
+	}
+
+	return unlock, nil
+}
+
+func (box *EnvBox) keyPath() (string, error) {
+	dataPath, err := box.DataPath()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dataPath, "secret.key"), nil
+}
+
+func (box *EnvBox) ReadKey() (string, error) {
+	keyPath, err := box.keyPath()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to get key path")
+	}
+
+	var key string
+
+	if helperKey, _ := box.GetCredHelperKey(); len(helperKey) > 0 {
+		logrus.Debugf("found cred helper key, using that")
+		key = helperKey
+	} else if pathKeyData, err := ioutil.ReadFile(keyPath); err == nil {
+		logrus.Debugf("found file key, using that")
+		key = strings.TrimSpace(string(pathKeyData))
+	}
+
+	if len(key) == 0 {
+		promptedKey, err := box.PromptForKey()
+		if err != nil {
+			return "", errors.Wrap(err, "unable prompt for key")
+		}
+
+		err = box.StoreKey(promptedKey)
+		if err != nil {
+			return "", errors.Wrap(err, "unable to set key")
+		}
+
+		key = promptedKey
+	}
+
+	if err := checkKey(key); err != nil {
+		return "", errors.Wrap(err, "stored key is not usable")
+	}
+
+	return key, nil
+}
+
+// checkKey checks that a key for existing data is usable, warning if it's in
+// the legacy format.
+func checkKey(key string) error {
+	if _, err := cipherKeys(key); err != nil {
+		return err
+	}
+	if !isHexKey(key) {
+		logrus.Warnf("key is in a legacy format, run envbox key rotate to replace it")
+	}
+	return nil
+}
+
+// PromptForKey prompts for an existing key, which can be in the legacy
+// format so that data stored with one can still be read.
+func (box *EnvBox) PromptForKey() (string, error) {
+	return box.promptForKey(true)
+}
+
+// promptForKey prompts for a key, only accepting the legacy format if
+// legacyOK is set.
+func (box *EnvBox) promptForKey(legacyOK bool) (string, error) {
+	key, err := box.PromptMasked("enter key: ")
+	if err != nil {
+		return "", errors.Wrap(err, "unable to prompt for key")
+	}
+
+	if legacyOK {
+		err = checkKey(key)
+	} else {
+		err = validateKey(key)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// PromptForPassphraseKey prompts for a passphrase, twice to guard against
+// typos, and derives a key from it with the salt stored in the data
+// directory.
+func (box *EnvBox) PromptForPassphraseKey() (string, error) {
+	passphrase, err := box.PromptConfirmed("enter passphrase: ", true)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to prompt for passphrase")
+	}
+
+	dataPath, err := box.DataPath()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to get data path")
+	}
+
+	salt, err := readOrCreateSalt(filepath.Join(dataPath, "secret.salt"))
+	if err != nil {
+		return "", err
+	}
+
+	return passphraseKey(passphrase, salt)
+}
+
+func (box *EnvBox) StoreKey(key string) error {
+
+	err := box.StoreCredHelperKey(key)
+	if err == nil {
+		logrus.Debugf("helper key stored")
+		return nil
+	} else if err != nil && err != helperNotFound {
+		return errors.Wrap(err, "unable to set with helper")
+	}
+
+	logrus.Debugf("falling back on path based storage")
+	keyPath, err := box.keyPath()
+	if err != nil {
+		return errors.Wrap(err, "unable to get key path")
+	}
+
+	return writeFileAtomic(keyPath, []byte(key), 0600)
+}
+
+func (box *EnvBox) ClearKey() error {
+	err := box.ClearCredHelperKey()
+	if err == nil {
+		logrus.Debugf("helper key cleared")
+	} else if err != nil && err != helperNotFound {
+		return errors.Wrap(err, "unable to clear cred helper key")
+	}
+
+	logrus.Debugf("clearing out path based storage")
+	keyPath, err := box.keyPath()
+	if err != nil {
+		return errors.Wrap(err, "unable to get key path")
+	}
+	return os.Remove(keyPath)
+}
+
+// dataFile is one encrypted file from the data directory, along with the
+// variable stored in it or the reason it couldn't be loaded.
+type dataFile struct {
+	Path   string
+	EnvVar EnvVar
+	Err    error
+
+	// Headerless is set for files written before file headers existed.
+	Headerless bool
+
+	// ModTime is when the file was last written.
+	ModTime time.Time
+}
+
+// changed is when the file's variable group was last changed.  Files are
+// also rewritten by maintenance such as key rotation, so the group's Updated
+// time is used where it's recorded.
+func (file dataFile) changed() time.Time {
+	if file.EnvVar.Updated != nil {
+		return *file.EnvVar.Updated
+	}
+	return file.ModTime
+}
+
+// loadDataFiles opens every file in the data directory.  Files that can't be
+// opened are returned with Err set rather than failing the whole load.
+func (box *EnvBox) loadDataFiles(key string) ([]dataFile, error) {
+	if _, err := cipherKeys(key); err != nil {
+		return nil, err
+	}
+
+	fileNames, err := box.dataFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []dataFile
+	for _, fileName := range fileNames {
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read file")
+		}
+
+		envVar, err := openEnvVar(key, data)
+		envVar.Path = fileName
+
+		_, hasHeader := parseHeader(data)
+
+		file := dataFile{Path: fileName, EnvVar: envVar, Err: err, Headerless: !hasHeader}
+		if info, err := os.Stat(fileName); err == nil {
+			file.ModTime = info.ModTime()
+			file.EnvVar.ModTime = info.ModTime()
+		}
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+func (box *EnvBox) LoadEnvVars(key string) (map[string]EnvVar, error) {
+	vars := make(map[string]EnvVar)
+
+	files, err := box.loadDataFiles(key)
+	if err != nil {
+		return vars, err
+	}
+
+	for _, file := range files {
+		if file.Err != nil {
+			logrus.Warnf("skipping %s: %s (run envbox doctor for details)", filepath.Base(file.Path), file.Err)
+			continue
+		}
+
+		vars[file.EnvVar.Name] = file.EnvVar
+	}
+
+	// when a name is stored more than once, use the newest file
+	for name, dups := range duplicateFiles(files) {
+		var descs []string
+		for _, file := range dups {
+			descs = append(descs, describeFile(file))
+		}
+		logrus.Warnf("variable %s is stored in multiple files, using the newest (run envbox dedupe): %s", name, strings.Join(descs, ", "))
+
+		vars[name] = dups[0].EnvVar
+	}
+
+	return vars, nil
+}
+
+// duplicateFiles finds names that are stored in more than one file, returning
+// the files for each name sorted newest first.
+func duplicateFiles(files []dataFile) map[string][]dataFile {
+	byName := make(map[string][]dataFile)
+	for _, file := range files {
+		if file.Err == nil {
+			byName[file.EnvVar.Name] = append(byName[file.EnvVar.Name], file)
+		}
+	}
+
+	dups := make(map[string][]dataFile)
+	for name, named := range byName {
+		if len(named) > 1 {
+			sort.SliceStable(named, func(i, j int) bool {
+				return named[i].changed().After(named[j].changed())
+			})
+			dups[name] = named
+		}
+	}
+
+	return dups
+}
+
+// describeFile formats a file's name and when it was changed for messages.
+func describeFile(file dataFile) string {
+	return fmt.Sprintf("%s (%s)", filepath.Base(file.Path), file.changed().Local().Format("2006-01-02 15:04:05"))
+}
+
+// dataFiles returns the paths of all of the encrypted files in the data
+// directory.
+func (box *EnvBox) dataFiles() ([]string, error) {
+	dataPath, err := box.DataPath()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get data path")
+	}
+	files, err := ioutil.ReadDir(dataPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read directory")
+	}
+
+	var fileNames []string
+	for _, info := range files {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".envenc") {
+			fileNames = append(fileNames, filepath.Join(dataPath, info.Name()))
+		}
+	}
+
+	return fileNames, nil
+}
+
+// varSummary is the machine readable description of a variable group, used
+// by list and show.
+type varSummary struct {
+	Name        string            `json:"name"`
+	Keys        []string          `json:"keys"`
+	Tags        []string          `json:"tags,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Source      string            `json:"source,omitempty"`
+	Created     *time.Time        `json:"created,omitempty"`
+	Updated     *time.Time        `json:"updated,omitempty"`
+	Path        string            `json:"path"`
+	Modified    time.Time         `json:"modified"`
+	Vars        map[string]string `json:"vars,omitempty"`
+	Encodings   map[string]string `json:"encodings,omitempty"`
+}
+
+func newVarSummary(envVar EnvVar) varSummary {
+	return varSummary{
+		Name:        envVar.Name,
+		Keys:        sortedKeys(envVar.Vars),
+		Tags:        envVar.Tags,
+		Description: envVar.Description,
+		Source:      envVar.Source,
+		Created:     envVar.Created,
+		Updated:     envVar.Updated,
+		Path:        envVar.Path,
+		Modified:    envVar.ModTime,
+	}
+}
+
+// sortedNames returns the names of vars in sorted order.
+func sortedNames(vars map[string]EnvVar) []string {
+	var names []string
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ListVariables prints the variable groups that match filter, sorted by name,
+// and the variables they expose.  With jsonOut set, the list is printed as
+// JSON, and with namesOnly set, only the group names are printed, one per
+// line.
+func (box *EnvBox) ListVariables(filter VarFilter, jsonOut, namesOnly bool) error {
+	key, err := box.ReadKey()
+	if err != nil {
+		return errors.Wrap(err, "unable to read key")
+	}
+
+	vars, err := box.LoadEnvVars(key)
+	if err != nil {
+		return errors.Wrap(err, "unable to load vars")
+	}
+
+	vars, err = filter.Apply(vars)
+	if err != nil {
+		return err
+	}
+
+	if jsonOut {
+		summaries := []varSummary{}
+		for _, name := range sortedNames(vars) {
+			summaries = append(summaries, newVarSummary(vars[name]))
+		}
+		return writeJSON(box.Writer, summaries)
+	}
+
+	if namesOnly {
+		for _, name := range sortedNames(vars) {
+			fmt.Fprintf(box.Writer, "%s\n", name)
+		}
+		return nil
+	}
+
+	for _, name := range sortedNames(vars) {
+		fmt.Fprintf(box.Writer, "%s: %s", name, strings.Join(sortedKeys(vars[name].Vars), ", "))
+		if tags := vars[name].Tags; len(tags) > 0 {
+			fmt.Fprintf(box.Writer, " [%s]", strings.Join(tags, ", "))
+		}
+		if description := vars[name].Description; len(description) > 0 {
+			fmt.Fprintf(box.Writer, " - %s", description)
+		}
+		fmt.Fprintf(box.Writer, "\n")
+	}
+
+	return nil
+}
+
+// CheckDataFiles reports the state of every file in the data directory:
+// whether it opens with the current key, is truncated, holds data that can't
+// be decoded, or shares its name with another file.  With quarantine set,
+// files that can't be loaded are moved to a subdirectory so they stop
+// generating warnings.
+func (box *EnvBox) CheckDataFiles(quarantine bool) error {
+	key, err := box.ReadKey()
+	if err != nil {
+		return errors.Wrap(err, "unable to read key")
+	}
+
+	unlock, err := box.lockData()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	files, err := box.loadDataFiles(key)
+	if err != nil {
+		return errors.Wrap(err, "unable to load files")
+	}
+
+	dups := duplicateFiles(files)
+
+	problems := 0
+	for _, file := range files {
+		var status string
+		switch errors.Cause(file.Err) {
+		case nil:
+			status = "ok"
+			if _, ok := dups[file.EnvVar.Name]; ok {
+				status = "duplicate name"
+				problems++
+			}
+		case errWrongKey:
+			status = "wrong key"
+		case errCorrupt:
+			status = "corrupt"
+		case errUnrecognized:
+			status = "unrecognized"
+		case errTruncated:
+			status = "truncated"
+		case errBadJSON:
+			status = "bad json"
+		default:
+			status = file.Err.Error()
+		}
+
+		fmt.Fprintf(box.Writer, "%-15s %s", status, filepath.Base(file.Path))
+		if file.Err == nil {
+			fmt.Fprintf(box.Writer, " (%s)", file.EnvVar.Name)
+		}
+
+		if file.Err != nil {
+			problems++
+
+			if quarantine {
+				dest, err := box.quarantineFile(file.Path)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(box.Writer, " -> %s", dest)
+			}
+		}
+		fmt.Fprintf(box.Writer, "\n")
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("found %d problem(s)", problems)
+	}
+	return nil
+}
+
+// MigrateDataFiles rewrites files stored in older formats, either the single
+// variable layout or without a file header, in the current format.  With
+// dryRun set, it only reports what would change.
+func (box *EnvBox) MigrateDataFiles(dryRun bool) error {
+	key, err := box.ReadKey()
+	if err != nil {
+		return errors.Wrap(err, "unable to read key")
+	}
+
+	unlock, err := box.lockData()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	files, err := box.loadDataFiles(key)
+	if err != nil {
+		return errors.Wrap(err, "unable to load files")
+	}
+
+	action := "migrated"
+	if dryRun {
+		action = "would migrate"
+	}
+
+	migrated := 0
+	for _, file := range files {
+		if file.Err != nil {
+			logrus.Warnf("skipping %s: %s", filepath.Base(file.Path), file.Err)
+			continue
+		}
+
+		var reasons []string
+		if len(file.EnvVar.LegacyExposed) > 0 {
+			reasons = append(reasons, "single variable layout")
+		}
+		if file.Headerless {
+			reasons = append(reasons, "no file header")
+		}
+		if len(reasons) == 0 {
+			continue
+		}
+
+		if !dryRun {
+			if err := box.writeEnvVar(key, &file.EnvVar); err != nil {
+				return errors.Wrapf(err, "unable to migrate %s", file.Path)
+			}
+		}
+
+		fmt.Fprintf(box.Writer, "%s %s (%s): %s\n", action, filepath.Base(file.Path), file.EnvVar.Name, strings.Join(reasons, ", "))
+		migrated++
+	}
+
+	fmt.Fprintf(box.Writer, "%s %d file(s)\n", action, migrated)
+
+	return nil
+}
+
+// Dedupe resolves names that are stored in more than one file by keeping one
+// of the files and moving the rest to the quarantine directory.  With newest
+// set, the most recently written file is kept, otherwise the user picks.
+func (box *EnvBox) Dedupe(newest bool) error {
+	key, err := box.ReadKey()
+	if err != nil {
+		return errors.Wrap(err, "unable to read key")
+	}
+
+	unlock, err := box.lockData()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	files, err := box.loadDataFiles(key)
+	if err != nil {
+		return errors.Wrap(err, "unable to load files")
+	}
+
+	dups := duplicateFiles(files)
+
+	var names []string
+	for name := range dups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		named := dups[name]
+
+		fmt.Fprintf(box.Writer, "%s is stored in %d files:\n", name, len(named))
+		for i, file := range named {
+			var keys []string
+			for k := range file.EnvVar.Vars {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			fmt.Fprintf(box.Writer, "  %d) %s: %s\n", i+1, describeFile(file), strings.Join(keys, ", "))
+		}
+
+		keep := 0
+		if !newest {
+			choice, err := box.PromptFor(fmt.Sprintf("keep which [1-%d]: ", len(named)))
+			if err != nil {
+				return errors.Wrap(err, "error reading choice")
+			}
+
+			keep, err = strconv.Atoi(choice)
+			if err != nil || keep < 1 || keep > len(named) {
+				return fmt.Errorf("invalid choice %q", choice)
+			}
+			keep--
+		}
+
+		for i, file := range named {
+			if i == keep {
+				continue
+			}
+
+			dest, err := box.quarantineFile(file.Path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(box.Writer, "moved %s to %s\n", filepath.Base(file.Path), dest)
+		}
+	}
+
+	return nil
+}
+
+// quarantineFile moves a data file into the quarantine subdirectory of the
+// data directory, returning the new path.
+func (box *EnvBox) quarantineFile(fileName string) (string, error) {
+	dataPath, err := box.DataPath()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to get data path")
+	}
+
+	quarantinePath := filepath.Join(dataPath, "quarantine")
+	if err := os.MkdirAll(quarantinePath, 0700); err != nil {
+		return "", errors.Wrap(err, "unable to create quarantine directory")
+	}
+
+	dest := filepath.Join(quarantinePath, filepath.Base(fileName))
+	if err := os.Rename(fileName, dest); err != nil {
+		return "", errors.Wrap(err, "unable to quarantine file")
+	}
+
+	return dest, nil
+}
+
+// newKey generates a new random key.
+func newKey() (string, error) {
+	var pass [32]byte
+	if _, err := io.ReadFull(rand.Reader, pass[:]); err != nil {
+		return "", errors.Wrap(err, "unable to read random")
+	}
+
+	return hex.EncodeToString(pass[:]), nil
+}
+
+func (box *EnvBox) GenerateNewKey(set bool) error {
+	key, err := newKey()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(box.Writer, "%s\n", key)
+
+	if set {
+		// TODO: warn when overriding existing key
+		return box.StoreKey(key)
+	}
 	return nil
 }
 
-func (box *EnvBox) withFoundKey(name string, fun func(EnvVar) error) error {
+// RotateKey re-encrypts every data file under a new key, which is either
+// generated, prompted for or derived from a passphrase.  All files are
+// decrypted up front, so nothing is written unless every file can be read
+// with the current key.  If any write fails, or the new key can't be stored,
+// the original files are put back.
+func (box *EnvBox) RotateKey(prompt, passphrase bool) error {
 	key, err := box.ReadKey()
 	if err != nil {
 		return errors.Wrap(err, "unable to read key")
 	}
 
-	vars, err := box.LoadEnvVars(key)
+	// the new key is settled before locking, so nothing waits on a prompt
+	var rotatedKey string
+	if passphrase {
+		rotatedKey, err = box.PromptForPassphraseKey()
+		if err != nil {
+			return err
+		}
+	} else if prompt {
+		rotatedKey, err = box.promptForKey(false)
+		if err != nil {
+			return errors.Wrap(err, "unable to prompt for key")
+		}
+	} else {
+		rotatedKey, err = newKey()
+		if err != nil {
+			return err
+		}
+	}
+
+	unlock, err := box.lockData()
 	if err != nil {
-		return errors.Wrap(err, "unable to load env vars")
+		return err
+	}
+	defer unlock()
+
+	fileNames, err := box.dataFiles()
+	if err != nil {
+		return err
+	}
+
+	originals := make(map[string][]byte)
+	var envVars []EnvVar
+	for _, fileName := range fileNames {
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return errors.Wrap(err, "unable to read file")
+		}
+
+		envVar, err := openEnvVar(key, data)
+		if err != nil {
+			return errors.Wrapf(err, "unable to open %s with current key", fileName)
+		}
+		envVar.Path = fileName
+
+		originals[fileName] = data
+		envVars = append(envVars, envVar)
+	}
+
+	if !passphrase && !prompt {
+		fmt.Fprintf(box.Writer, "%s\n", rotatedKey)
+	}
+
+	sealed := make(map[string][]byte)
+	for _, envVar := range envVars {
+		out, err := sealEnvVar(rotatedKey, envVar)
+		if err != nil {
+			return errors.Wrap(err, "unable to seal data")
+		}
+		sealed[envVar.Path] = out
+	}
+
+	var written []string
+	rollback := func(cause error) error {
+		var failed []string
+		for _, fileName := range written {
+			if err := writeFileAtomic(fileName, originals[fileName], 0600); err != nil {
+				failed = append(failed, fileName)
+			}
+		}
+
+		if len(failed) > 0 {
+			return errors.Wrapf(cause, "rollback failed, these files use the new key: %s", strings.Join(failed, ", "))
+		}
+		return errors.Wrap(cause, "rotation rolled back")
+	}
+
+	for _, fileName := range fileNames {
+		if err := writeFileAtomic(fileName, sealed[fileName], 0600); err != nil {
+			return rollback(errors.Wrapf(err, "unable to write %s", fileName))
+		}
+		written = append(written, fileName)
+	}
+
+	if err := box.StoreKey(rotatedKey); err != nil {
+		return rollback(errors.Wrap(err, "unable to store new key"))
 	}
 
-	if envVar, ok := vars[name]; ok {
-		return fun(envVar)
+	logrus.Debugf("rotated key for %d files", len(written))
+	return nil
+}
+
+func (box *EnvBox) PromptAndStoreKey(passphrase bool) error {
+	var key string
+	var err error
+	if passphrase {
+		key, err = box.PromptForPassphraseKey()
 	} else {
-		return fmt.Errorf("variable %s not found", name)
+		key, err = box.PromptForKey()
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to prompt for key")
+	}
+
+	return box.StoreKey(key)
+}
+
+func (box *EnvBox) ShowKey() error {
+	key, err := box.ReadKey()
+	if err != nil {
+		return errors.Wrap(err, "unable to read key")
 	}
+
+	fmt.Fprintf(box.Writer, "%s\n", key)
+
 	return nil
 }
 
-func (box *EnvBox) ShowVariable(name string) error {
+func (box *EnvBox) withFoundKey(name string, fun func(EnvVar) error) error {
+	key, err := box.ReadKey()
+	if err != nil {
+		return errors.Wrap(err, "unable to read key")
+	}
+
+	envVar, err := box.findVariable(key, name)
+	if err != nil {
+		return err
+	}
+
+	return fun(envVar)
+}
+
+// findVariable loads the named variable group.
+func (box *EnvBox) findVariable(key, name string) (EnvVar, error) {
+	vars, err := box.LoadEnvVars(key)
+	if err != nil {
+		return EnvVar{}, errors.Wrap(err, "unable to load env vars")
+	}
+
+	envVar, ok := vars[name]
+	if !ok {
+		return EnvVar{}, fmt.Errorf("variable %s not found", name)
+	}
+	return envVar, nil
+}
+
+// ShowVariable prints a variable group, including values.  With jsonOut set,
+// it's printed as JSON.
+func (box *EnvBox) ShowVariable(name string, jsonOut bool) error {
 	return box.withFoundKey(name, func(envVar EnvVar) error {
+		if jsonOut {
+			// values are left encoded, as JSON strings can't hold arbitrary
+			// bytes
+			summary := newVarSummary(envVar)
+			summary.Vars = envVar.Vars
+			summary.Encodings = envVar.Encodings
+			return writeJSON(box.Writer, summary)
+		}
+
+		values, err := envVar.Values()
+		if err != nil {
+			return err
+		}
+
 		fmt.Fprintf(box.Writer, "name: %s\n", envVar.Name)
+		if len(envVar.Description) > 0 {
+			fmt.Fprintf(box.Writer, "description: %s\n", envVar.Description)
+		}
+		if len(envVar.Tags) > 0 {
+			fmt.Fprintf(box.Writer, "tags: %s\n", strings.Join(envVar.Tags, ", "))
+		}
+		if len(envVar.Source) > 0 {
+			fmt.Fprintf(box.Writer, "source: %s\n", envVar.Source)
+		}
+		if envVar.Created != nil {
+			fmt.Fprintf(box.Writer, "created: %s\n", envVar.Created.Local().Format("2006-01-02 15:04:05"))
+		}
+		if envVar.Updated != nil {
+			fmt.Fprintf(box.Writer, "updated: %s\n", envVar.Updated.Local().Format("2006-01-02 15:04:05"))
+		}
 		fmt.Fprintf(box.Writer, "vars:\n")
-		for k, v := range envVar.Vars {
-			fmt.Fprintf(box.Writer, "  %s: %s\n", k, v)
+		for _, k := range sortedKeys(values) {
+			fmt.Fprintf(box.Writer, "  %s: %s\n", k, values[k])
 		}
 		return nil
 	})
 }
 
-func (box *EnvBox) ExportVariable(name string) error {
+// ExportVariable writes a variable group's variables in one of the
+// exportFormats, such as shell commands to eval.
+func (box *EnvBox) ExportVariable(name, format string) error {
+	formatter, ok := exportFormats[format]
+	if !ok {
+		return fmt.Errorf("unknown format %s", format)
+	}
+
 	return box.withFoundKey(name, func(envVar EnvVar) error {
-		for k, v := range envVar.Vars {
-			// TODO: better value escaping
-			fmt.Fprintf(box.Writer, "export %s=%q\n", k, v)
+		values, err := envVar.Values()
+		if err != nil {
+			return err
 		}
-		return nil
+
+		return formatter(box.Writer, values)
 	})
 }
 
+// RemoveVariable deletes the file a variable group is stored in.  The key is
+// read, which might prompt, before the data directory is locked.
 func (box *EnvBox) RemoveVariable(name string) error {
-	return box.withFoundKey(name, func(envVar EnvVar) error {
-		err := os.Remove(envVar.Path)
-		if err != nil {
-			return errors.Wrap(err, "unable to remove file")
-		}
-		return nil
-	})
+	key, err := box.ReadKey()
+	if err != nil {
+		return errors.Wrap(err, "unable to read key")
+	}
+
+	unlock, err := box.lockData()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	envVar, err := box.findVariable(key, name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(envVar.Path); err != nil {
+		return errors.Wrap(err, "unable to remove file")
+	}
+	return nil
+}
+
+// RunOptions controls how RunCommandWithEnv runs a command.
+type RunOptions struct {
+	// AsFiles lists keys to write to temporary files, setting the variable
+	// to the file's path instead of the value.
+	AsFiles []string
+
+	// Supervise runs the command as a child process instead of replacing
+	// envbox with it.  It's implied by options that need to clean up after
+	// the command.
+	Supervise bool
+
+	// Redact replaces exposed values in the command's output with asterisks.
+	Redact bool
+
+	// RedactEncoded also redacts base64 and URL encoded forms of values.
+	RedactEncoded bool
+
+	// AllowMissing runs the command even if some groups can't be found.
+	AllowMissing bool
+
+	// Override lets groups later in the list replace values exposed under the
+	// same name by earlier ones, instead of that being an error.
+	Override bool
+
+	// HostEnv selects which of envbox's own environment variables are passed
+	// on to the command.
+	HostEnv EnvFilter
+
+	// Prefix is added to the name of every exposed variable.
+	Prefix string
+
+	// Tags selects groups with any of these tags, in addition to those
+	// named.
+	Tags []string
+
+	// All selects every group.
+	All bool
+
+	// DryRun prints the names that would be exposed, and where they come
+	// from, instead of running the command.
+	DryRun bool
 }
 
-func (box *EnvBox) RunCommandWithEnv(varNames, command []string) error {
+// RunCommandWithEnv runs a command with the variables from the named groups
+// added to its environment.  By default envbox is replaced by the command, but
+// when supervised, the command is run as a child process and cleanup hooks,
+// such as removing files for AsFiles, run after it exits.
+func (box *EnvBox) RunCommandWithEnv(varNames []string, runOpts RunOptions, command []string) error {
+	if len(command) == 0 && !runOpts.DryRun {
+		return fmt.Errorf("no command given")
+	}
+
 	key, err := box.ReadKey()
 	if err != nil {
 		return errors.Wrap(err, "unable to read key")
@@ -412,38 +1791,302 @@ func (box *EnvBox) RunCommandWithEnv(varNames, command []string) error {
 		return errors.Wrap(err, "unable to load env vars")
 	}
 
-	var exposeVars []EnvVar
-	for _, varName := range varNames {
-		if envVar, ok := vars[varName]; ok {
-			exposeVars = append(exposeVars, envVar)
-		} else {
-			fmt.Fprintf(os.Stdout, "unable to find %s\n", varName)
+	groups, err := selectGroups(vars, varNames, runOpts)
+	if err != nil {
+		return err
+	}
+
+	exposedValues, exposedBy, err := exposeValues(vars, groups, runOpts)
+	if err != nil {
+		return err
+	}
+
+	if runOpts.DryRun {
+		asFiles := make(map[string]bool)
+		for _, fileKey := range runOpts.AsFiles {
+			asFiles[fileKey] = true
+		}
+
+		for _, exposed := range sortedKeys(exposedValues) {
+			if asFiles[exposed] {
+				fmt.Fprintf(box.Writer, "%s (from %s, as a file)\n", exposed, exposedBy[exposed])
+			} else {
+				fmt.Fprintf(box.Writer, "%s (from %s)\n", exposed, exposedBy[exposed])
+			}
 		}
+		return nil
 	}
 
-	var useEnv []string
+	hostEnv, err := runOpts.HostEnv.Apply(box.Environ())
+	if err != nil {
+		return err
+	}
 
-	hostEnv := os.Environ()
+	var useEnv []string
 	for _, hostVar := range hostEnv {
-		conflictFound := false
-		for _, expVar := range exposeVars {
-			for exposed, _ := range expVar.Vars {
-				if strings.HasPrefix(hostVar, fmt.Sprintf("%s=", exposed)) {
-					conflictFound = true
+		hostName := strings.SplitN(hostVar, "=", 2)[0]
+		if _, ok := exposedValues[hostName]; !ok {
+			useEnv = append(useEnv, hostVar)
+		}
+	}
+
+	// cleanups run once a supervised command exits, or if an error keeps it
+	// from running
+	var cleanups []func()
+	runCleanups := func() {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+		cleanups = nil
+	}
+	defer runCleanups()
+
+	supervise := runOpts.Supervise
+
+	var stdout, stderr io.Writer = box.Writer, os.Stderr
+	if runOpts.Redact || runOpts.RedactEncoded {
+		supervise = true
+
+		secrets := redactSecrets(exposedValues, runOpts.RedactEncoded)
+		stdoutRedact := newRedactWriter(box.Writer, secrets)
+		stderrRedact := newRedactWriter(os.Stderr, secrets)
+		stdout, stderr = stdoutRedact, stderrRedact
+		cleanups = append(cleanups, func() {
+			stdoutRedact.Close()
+			stderrRedact.Close()
+		})
+	}
+
+	if len(runOpts.AsFiles) > 0 {
+		supervise = true
+
+		secretDir, err := box.secretFileDir()
+		if err != nil {
+			return err
+		}
+		cleanups = append(cleanups, func() { os.RemoveAll(secretDir) })
+
+		for _, fileKey := range runOpts.AsFiles {
+			value, ok := exposedValues[fileKey]
+			if !ok {
+				return fmt.Errorf("unable to expose %s as a file, no variable exposes it", fileKey)
+			}
+
+			fileName := filepath.Join(secretDir, fileKey)
+			if err := ioutil.WriteFile(fileName, []byte(value), 0600); err != nil {
+				return errors.Wrap(err, "unable to write secret file")
+			}
+			exposedValues[fileKey] = fileName
+		}
+	}
+
+	for exposed, value := range exposedValues {
+		if strings.ContainsRune(value, 0) {
+			return fmt.Errorf("value of %s contains a NUL byte, which can't be put in the environment", exposed)
+		}
+		useEnv = append(useEnv, fmt.Sprintf("%s=%s", exposed, value))
+	}
+
+	if !supervise {
+		return box.ExecCommandWithEnv(command[0], command[1:], useEnv)
+	}
+
+	status, err := box.SuperviseCommandWithEnv(command[0], command[1:], useEnv, stdout, stderr)
+	if err != nil {
+		return err
+	}
+
+	// Exit doesn't return, so clean up first
+	runCleanups()
+	box.Exit(status)
+	return nil
+}
+
+// selectGroups resolves the groups to expose: those named in varNames, where
+// names can be globs, in order, followed by any with one of runOpts.Tags or,
+// with runOpts.All, every group.
+func selectGroups(vars map[string]EnvVar, varNames []string, runOpts RunOptions) ([]groupSpec, error) {
+	if len(varNames) == 0 && len(runOpts.Tags) == 0 && !runOpts.All {
+		return nil, fmt.Errorf("no variables selected, use -e, --tag or --all")
+	}
+
+	// a group picked up again by a tag or --all is only exposed once, as it
+	// was first selected.  Each -e for a group has to take effect though, so
+	// their mappings are merged, and mixing mapped and unmapped is an error.
+	var selected []groupSpec
+	index := make(map[string]int)
+	add := func(gs groupSpec, byName bool) error {
+		i, ok := index[gs.Name]
+		if !ok {
+			index[gs.Name] = len(selected)
+			selected = append(selected, gs)
+			return nil
+		}
+		if !byName {
+			return nil
+		}
+
+		if (len(selected[i].Mappings) == 0) != (len(gs.Mappings) == 0) {
+			return fmt.Errorf("%s is selected both with and without key mappings, combine them in one -e", gs.Name)
+		}
+		for _, mapping := range gs.Mappings {
+			if !selected[i].hasMapping(mapping) {
+				selected[i].Mappings = append(selected[i].Mappings, mapping)
+			}
+		}
+		return nil
+	}
+	missing := func(format string, args ...interface{}) error {
+		if !runOpts.AllowMissing {
+			return fmt.Errorf(format, args...)
+		}
+		logrus.Warnf(format+", skipping it", args...)
+		return nil
+	}
+
+	names := sortedNames(vars)
+	for _, spec := range varNames {
+		gs, err := parseGroupSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := vars[gs.Name]; ok {
+			if err := add(gs, true); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !strings.ContainsAny(gs.Name, "*?[") {
+			if err := missing("unable to find %s", gs.Name); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		match, err := VarFilter{}.matcher(gs.Name)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, name := range names {
+			if match(name) {
+				if err := add(groupSpec{Name: name, Mappings: gs.Mappings}, true); err != nil {
+					return nil, err
 				}
+				found = true
+			}
+		}
+		if !found {
+			if err := missing("no variables match %s", gs.Name); err != nil {
+				return nil, err
 			}
 		}
+	}
 
-		if !conflictFound {
-			useEnv = append(useEnv, hostVar)
+	for _, tag := range runOpts.Tags {
+		tagged, err := VarFilter{Tag: tag}.Apply(vars)
+		if err != nil {
+			return nil, err
+		}
+		if len(tagged) == 0 {
+			if err := missing("no variables are tagged %s", tag); err != nil {
+				return nil, err
+			}
+		}
+		for _, name := range sortedNames(tagged) {
+			add(groupSpec{Name: name}, false)
+		}
+	}
+
+	if runOpts.All {
+		for _, name := range names {
+			add(groupSpec{Name: name}, false)
+		}
+	}
+
+	return selected, nil
+}
+
+// exposeValues collects the values the selected groups expose, after any key
+// mappings and prefix, along with which group exposes each name.  No two
+// groups may expose the same name, unless runOpts allows it.
+func exposeValues(vars map[string]EnvVar, groups []groupSpec, runOpts RunOptions) (map[string]string, map[string]string, error) {
+	exposedValues := make(map[string]string)
+	exposedBy := make(map[string]string)
+
+	if len(runOpts.Prefix) > 0 && !envNamePattern.MatchString(runOpts.Prefix) {
+		return nil, nil, fmt.Errorf("invalid prefix %s", runOpts.Prefix)
+	}
+
+	for _, gs := range groups {
+		values, err := vars[gs.Name].Values()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		values, err = gs.apply(values, runOpts.Prefix)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, exposed := range sortedKeys(values) {
+			if other, ok := exposedBy[exposed]; ok {
+				if !runOpts.Override {
+					return nil, nil, fmt.Errorf("%s is exposed by both %s and %s, use --override to let later groups win", exposed, other, gs.Name)
+				}
+				logrus.Debugf("%s from %s overrides %s", exposed, gs.Name, other)
+			}
+			exposedValues[exposed] = values[exposed]
+			exposedBy[exposed] = gs.Name
 		}
 	}
 
-	for _, expVar := range exposeVars {
-		for exposed, value := range expVar.Vars {
-			useEnv = append(useEnv, fmt.Sprintf("%s=%s", exposed, value))
+	return exposedValues, exposedBy, nil
+}
+
+// redactSecrets lists the values to redact from a command's output.  Very
+// short values would mangle unrelated output, so they're left alone.
+func redactSecrets(exposedValues map[string]string, encoded bool) []string {
+	var secrets []string
+	for _, exposed := range sortedKeys(exposedValues) {
+		value := exposedValues[exposed]
+		if len(value) < minRedactLength {
+			if len(value) > 0 {
+				logrus.Warnf("not redacting %s, its value is too short", exposed)
+			}
+			continue
+		}
+
+		secrets = append(secrets, value)
+		if encoded {
+			secrets = append(secrets, encodedForms(value)...)
 		}
 	}
+	return secrets
+}
+
+// secretFileDir creates a private directory for secrets exposed as files.
+// It's placed in memory backed storage where that can be found, so secrets
+// don't end up on disk.
+func (box *EnvBox) secretFileDir() (string, error) {
+	base := box.Getenv("XDG_RUNTIME_DIR")
+	if len(base) == 0 && box.FileExists("/dev/shm") {
+		base = "/dev/shm"
+	}
+	if len(base) == 0 {
+		base = os.TempDir()
+	}
+
+	dir, err := ioutil.TempDir(base, "envbox-")
+	if err != nil {
+		return "", errors.Wrap(err, "unable to create secret file directory")
+	}
+
+	if err := os.Chmod(dir, 0700); err != nil {
+		os.RemoveAll(dir)
+		return "", errors.Wrap(err, "unable to secure secret file directory")
+	}
 
-	return box.ExecCommandWithEnv(command[0], command[1:], useEnv)
+	return dir, nil
 }
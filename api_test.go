@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -18,3 +24,833 @@ func TestStoreKey(t *testing.T) {
 	fileData, _ := ioutil.ReadFile(filepath.Join(tu.testSystem.homePath, ".local/share/envbox/secret.key"))
 	assert.Equal(string(fileData), "testkey")
 }
+
+func TestSetVariable(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	key := testKey
+	assert.Nil(box.StoreKey(key))
+
+	valueFile := filepath.Join(tu.testSystem.homePath, "value")
+	assert.Nil(ioutil.WriteFile(valueFile, []byte("first\n"), 0600))
+	assert.Nil(box.AddVariable("token", "TOKEN", ValueOptions{File: valueFile}, VarMetadata{}, nil, false))
+
+	vars, err := box.LoadEnvVars(key)
+	assert.Nil(err)
+	origPath := vars["token"].Path
+
+	assert.Nil(ioutil.WriteFile(valueFile, []byte("second\n"), 0600))
+	assert.Nil(box.SetVariable("token", "TOKEN", ValueOptions{File: valueFile}, VarMetadata{}))
+	assert.Nil(box.SetVariable("token", "OTHER", ValueOptions{File: valueFile}, VarMetadata{}))
+
+	vars, err = box.LoadEnvVars(key)
+	assert.Nil(err)
+	assert.Len(vars, 1)
+	assert.Equal(origPath, vars["token"].Path)
+	assert.Equal(map[string]string{"TOKEN": "second", "OTHER": "second"}, vars["token"].Vars)
+
+	// without -e, a group's only key is changed, whatever its name
+	assert.Nil(storeTestVar(box, "github", map[string]string{"GITHUB_TOKEN": "old"}))
+	assert.Nil(box.SetVariable("github", "", ValueOptions{File: valueFile}, VarMetadata{}))
+	vars, _ = box.LoadEnvVars(key)
+	assert.Equal(map[string]string{"GITHUB_TOKEN": "second"}, vars["github"].Vars)
+
+	// with several keys, one has to be chosen
+	assert.NotNil(box.SetVariable("token", "", ValueOptions{File: valueFile}, VarMetadata{}))
+	assert.Nil(storeTestVar(box, "db", map[string]string{"db": "old", "DB_USER": "me"}))
+	assert.Nil(box.SetVariable("db", "", ValueOptions{File: valueFile}, VarMetadata{}))
+	vars, _ = box.LoadEnvVars(key)
+	assert.Equal(map[string]string{"db": "second", "DB_USER": "me"}, vars["db"].Vars)
+
+	assert.NotNil(box.SetVariable("missing", "", ValueOptions{File: valueFile}, VarMetadata{}))
+
+	// a missing variable is reported before prompting for its value
+	tu.answers = []string{"unused"}
+	assert.NotNil(box.SetVariable("missing", "", ValueOptions{}, VarMetadata{}))
+	assert.Equal([]string{"unused"}, tu.answers)
+}
+
+func TestUnsetAndRename(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	assert.Nil(box.StoreKey(testKey))
+	assert.Nil(storeTestVar(box, "aws", map[string]string{"ID": "id", "SECRET": "secret", "SESSION": "session"}))
+	assert.Nil(storeTestVar(box, "other", map[string]string{"OTHER": "other"}))
+
+	assert.Nil(box.UnsetVariable("aws", []string{"SESSION"}))
+	assert.NotNil(box.UnsetVariable("aws", []string{"SESSION"}))
+	assert.NotNil(box.UnsetVariable("aws", []string{"ID", "SECRET"}))
+
+	// a key given twice still leaves the others
+	assert.Nil(storeTestVar(box, "pair", map[string]string{"A": "a", "B": "b"}))
+	assert.Nil(box.UnsetVariable("pair", []string{"A", "A"}))
+
+	assert.Nil(box.RenameKey("aws", "ID", "AWS_ACCESS_KEY_ID"))
+	assert.NotNil(box.RenameKey("aws", "SECRET", "AWS_ACCESS_KEY_ID"))
+
+	assert.Nil(box.RenameVariable("aws", "aws-prod"))
+	assert.NotNil(box.RenameVariable("aws-prod", "other"))
+
+	vars, err := box.LoadEnvVars(testKey)
+	assert.Nil(err)
+	assert.Len(vars, 3)
+	assert.Equal(map[string]string{"AWS_ACCESS_KEY_ID": "id", "SECRET": "secret"}, vars["aws-prod"].Vars)
+	assert.Equal(map[string]string{"B": "b"}, vars["pair"].Vars)
+}
+
+func TestRotateKey(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	assert.Nil(box.StoreKey(testKey))
+	assert.Nil(storeTestVar(box, "one", map[string]string{"ONE": "1"}))
+	assert.Nil(storeTestVar(box, "two", map[string]string{"TWO": "2"}))
+
+	box.Writer = ioutil.Discard
+	assert.Nil(box.RotateKey(false, false))
+
+	rotated, err := box.ReadKey()
+	assert.Nil(err)
+	assert.NotEqual(testKey, rotated)
+
+	vars, err := box.LoadEnvVars(rotated)
+	assert.Nil(err)
+	assert.Len(vars, 2)
+	assert.Equal("2", vars["two"].Vars["TWO"])
+
+	vars, err = box.LoadEnvVars(testKey)
+	assert.Nil(err)
+	assert.Len(vars, 0)
+
+	// a prompted key is checked before anything is read
+	tu.answers = []string{"tooshort"}
+	assert.NotNil(box.RotateKey(true, false))
+	stillKey, err := box.ReadKey()
+	assert.Nil(err)
+	assert.Equal(rotated, stillKey)
+
+	prompted := strings.Repeat("ab", 32)
+	tu.answers = []string{prompted}
+	assert.Nil(box.RotateKey(true, false))
+	rotated, err = box.ReadKey()
+	assert.Nil(err)
+	assert.Equal(prompted, rotated)
+
+	// a file that can't be opened with the current key aborts rotation
+	assert.Nil(storeTestVar(box, "stale", map[string]string{"STALE": "stale"}))
+	assert.NotNil(box.RotateKey(false, false))
+
+	stillKey, err = box.ReadKey()
+	assert.Nil(err)
+	assert.Equal(rotated, stillKey)
+}
+
+func TestCheckDataFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	assert.Nil(box.StoreKey(testKey))
+	assert.Nil(storeTestVar(box, "good", map[string]string{"GOOD": "good"}))
+	assert.Nil(storeTestVar(box, "dup", map[string]string{"DUP": "one"}))
+	assert.Nil(storeTestVar(box, "dup", map[string]string{"DUP": "two"}))
+	assert.Nil(box.writeEnvVar("fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210", &EnvVar{Name: "other", Vars: map[string]string{"OTHER": "other"}}))
+
+	dataPath, _ := box.DataPath()
+	assert.Nil(ioutil.WriteFile(filepath.Join(dataPath, "short.envenc"), []byte("short"), 0600))
+
+	var out bytes.Buffer
+	box.Writer = &out
+	assert.NotNil(box.CheckDataFiles(true))
+	assert.Contains(out.String(), "truncated       short.envenc")
+	assert.Contains(out.String(), "wrong key")
+	assert.Contains(out.String(), "duplicate name")
+
+	quarantined, _ := ioutil.ReadDir(filepath.Join(dataPath, "quarantine"))
+	assert.Len(quarantined, 2)
+
+	vars, err := box.LoadEnvVars(testKey)
+	assert.Nil(err)
+	assert.Len(vars, 2)
+}
+
+func TestKeyFormats(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	_, err := box.LoadEnvVars("tooshort")
+	assert.NotNil(err)
+	assert.NotNil(validateKey(testKey[:32]))
+	assert.Nil(validateKey(testKey))
+
+	// files sealed by earlier versions used the first 32 characters of the key
+	assert.Nil(storeLegacyTestVar(box))
+
+	vars, err := box.LoadEnvVars(testKey)
+	assert.Nil(err)
+	assert.Equal(map[string]string{"OLD": "old"}, vars["old"].Vars)
+
+	// a legacy key can be entered to read existing data, but not as a new key
+	legacyKey := testKey[:32] + "not hex"
+	tu.answers = []string{legacyKey, legacyKey, "tooshort"}
+	prompted, err := box.PromptForKey()
+	assert.Nil(err)
+	assert.Equal(legacyKey, prompted)
+	_, err = box.promptForKey(false)
+	assert.NotNil(err)
+	_, err = box.PromptForKey()
+	assert.NotNil(err)
+
+	salt := []byte("saltsaltsaltsalt")
+	derived, err := passphraseKey("correct horse", salt)
+	assert.Nil(err)
+	assert.Nil(validateKey(derived))
+	again, _ := passphraseKey("correct horse", salt)
+	assert.Equal(derived, again)
+
+	_, err = passphraseKey("short", salt)
+	assert.NotNil(err)
+}
+
+func TestFileHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	sealed, err := sealEnvVar(testKey, EnvVar{Name: "test", Vars: map[string]string{"TEST": "test"}})
+	assert.Nil(err)
+
+	header, ok := parseHeader(sealed)
+	assert.True(ok)
+	assert.Equal(fileVersion, header.Version)
+	assert.Equal(kdfHex, header.KDF)
+
+	envVar, err := openEnvVar(testKey, sealed)
+	assert.Nil(err)
+	assert.Equal("test", envVar.Vars["TEST"])
+
+	_, err = openEnvVar("fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210", sealed)
+	assert.Equal(errWrongKey, err)
+
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 0xff
+	_, err = openEnvVar(testKey, tampered)
+	assert.Equal(errCorrupt, err)
+
+	newer := append([]byte{}, sealed...)
+	newer[len(fileMagic)] = fileVersion + 1
+	_, err = openEnvVar(testKey, newer)
+	assert.NotNil(err)
+
+	_, err = openEnvVar(testKey, bytes.Repeat([]byte("x"), 100))
+	assert.Equal(errUnrecognized, err)
+}
+
+func TestMigrateDataFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	assert.Nil(box.StoreKey(testKey))
+	assert.Nil(storeLegacyTestVar(box))
+	assert.Nil(storeTestVar(box, "new", map[string]string{"NEW": "new"}))
+
+	dataPath, _ := box.DataPath()
+	legacyPath := filepath.Join(dataPath, "old.envenc")
+	before, _ := ioutil.ReadFile(legacyPath)
+
+	var out bytes.Buffer
+	box.Writer = &out
+	assert.Nil(box.MigrateDataFiles(true))
+	assert.Contains(out.String(), "would migrate old.envenc (old): single variable layout, no file header")
+	after, _ := ioutil.ReadFile(legacyPath)
+	assert.Equal(before, after)
+
+	out.Reset()
+	assert.Nil(box.MigrateDataFiles(false))
+	assert.Contains(out.String(), "migrated 1 file(s)")
+
+	files, err := box.loadDataFiles(testKey)
+	assert.Nil(err)
+	assert.Len(files, 2)
+	for _, file := range files {
+		assert.Nil(file.Err)
+		assert.False(file.Headerless)
+		assert.Empty(file.EnvVar.LegacyExposed)
+	}
+
+	vars, _ := box.LoadEnvVars(testKey)
+	assert.Equal(map[string]string{"OLD": "old"}, vars["old"].Vars)
+}
+
+func TestConcurrentAdd(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	assert.Nil(box.StoreKey(testKey))
+
+	valueFile := filepath.Join(tu.testSystem.homePath, "value")
+	assert.Nil(ioutil.WriteFile(valueFile, []byte("value"), 0600))
+
+	results := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func() {
+			results <- box.AddVariable("same", "", ValueOptions{File: valueFile}, VarMetadata{}, nil, false)
+		}()
+	}
+
+	succeeded := 0
+	for i := 0; i < 10; i++ {
+		if <-results == nil {
+			succeeded++
+		}
+	}
+	assert.Equal(1, succeeded)
+
+	files, err := box.dataFiles()
+	assert.Nil(err)
+	assert.Len(files, 1)
+}
+
+func TestDedupe(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	assert.Nil(box.StoreKey(testKey))
+
+	older := EnvVar{Name: "dup", Vars: map[string]string{"DUP": "older"}}
+	newer := EnvVar{Name: "dup", Vars: map[string]string{"DUP": "newer"}}
+	assert.Nil(box.writeEnvVar(testKey, &newer))
+	assert.Nil(box.writeEnvVar(testKey, &older))
+	assert.Nil(os.Chtimes(older.Path, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)))
+
+	vars, err := box.LoadEnvVars(testKey)
+	assert.Nil(err)
+	assert.Equal("newer", vars["dup"].Vars["DUP"])
+
+	box.Writer = ioutil.Discard
+	assert.Nil(box.Dedupe(true))
+
+	files, err := box.dataFiles()
+	assert.Nil(err)
+	assert.Equal([]string{newer.Path}, files)
+}
+
+func TestImportDotenv(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	assert.Nil(box.StoreKey(testKey))
+	assert.Nil(storeTestVar(box, "app", map[string]string{"KEEP": "keep", "TOKEN": "old"}))
+
+	envFile := filepath.Join(tu.testSystem.homePath, ".env")
+	assert.Nil(ioutil.WriteFile(envFile, []byte("TOKEN=new\nexport OTHER='other'\n"), 0600))
+
+	box.Writer = ioutil.Discard
+	assert.Nil(box.ImportDotenv("app", envFile, true))
+
+	vars, err := box.LoadEnvVars(testKey)
+	assert.Nil(err)
+	assert.Equal(map[string]string{"KEEP": "keep", "TOKEN": "new", "OTHER": "other"}, vars["app"].Vars)
+
+	_, err = os.Stat(envFile)
+	assert.True(os.IsNotExist(err))
+}
+
+func TestListJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	assert.Nil(box.StoreKey(testKey))
+	assert.Nil(storeTestVar(box, "b", map[string]string{"B2": "2", "B1": "1"}))
+	assert.Nil(storeTestVar(box, "a", map[string]string{"A": "a"}))
+
+	var out bytes.Buffer
+	box.Writer = &out
+	assert.Nil(box.ListVariables(VarFilter{}, true, false))
+
+	var summaries []varSummary
+	assert.Nil(json.Unmarshal(out.Bytes(), &summaries))
+	assert.Len(summaries, 2)
+	assert.Equal("a", summaries[0].Name)
+	assert.Equal([]string{"B1", "B2"}, summaries[1].Keys)
+	assert.NotEmpty(summaries[1].Path)
+	assert.Nil(summaries[1].Vars)
+
+	out.Reset()
+	assert.Nil(box.ListVariables(VarFilter{}, false, true))
+	assert.Equal("a\nb\n", out.String())
+
+	out.Reset()
+	assert.Nil(box.ShowVariable("b", true))
+	var summary varSummary
+	assert.Nil(json.Unmarshal(out.Bytes(), &summary))
+	assert.Equal(map[string]string{"B2": "2", "B1": "1"}, summary.Vars)
+
+	out.Reset()
+	assert.Nil(box.ShowVariable("b", false))
+	assert.Contains(out.String(), "vars:\n  B1: 1\n  B2: 2\n")
+}
+
+func TestListFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	assert.Nil(box.StoreKey(testKey))
+	assert.Nil(storeTestVar(box, "aws-prod", map[string]string{"AWS_SECRET": "p", "AWS_ID": "p"}))
+	assert.Nil(storeTestVar(box, "aws-dev", map[string]string{"AWS_ID": "d"}))
+	assert.Nil(storeTestVar(box, "github", map[string]string{"GITHUB_TOKEN": "g"}))
+	assert.Nil(storeTestVar(box, "hub", map[string]string{"GITHUB_TOKEN": "h"}))
+
+	var out bytes.Buffer
+	box.Writer = &out
+
+	assert.Nil(box.ListVariables(VarFilter{}, false, false))
+	assert.Equal("aws-dev: AWS_ID\naws-prod: AWS_ID, AWS_SECRET\ngithub: GITHUB_TOKEN\nhub: GITHUB_TOKEN\n", out.String())
+
+	out.Reset()
+	assert.Nil(box.ListVariables(VarFilter{Pattern: "aws-*"}, false, true))
+	assert.Equal("aws-dev\naws-prod\n", out.String())
+
+	out.Reset()
+	assert.Nil(box.ListVariables(VarFilter{Key: "GITHUB_TOKEN"}, false, true))
+	assert.Equal("github\nhub\n", out.String())
+
+	out.Reset()
+	assert.Nil(box.ListVariables(VarFilter{Pattern: "SECRET$", Regex: true}, false, true))
+	assert.Equal("aws-prod\n", out.String())
+
+	assert.NotNil(box.ListVariables(VarFilter{Pattern: "(", Regex: true}, false, true))
+}
+
+func TestPromptedValues(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	assert.Nil(box.StoreKey(testKey))
+
+	// masked by default
+	tu.answers = []string{"secret"}
+	assert.Nil(box.AddVariable("masked", "", ValueOptions{}, VarMetadata{}, nil, false))
+	assert.Equal([]testPrompt{{"value: ", true}}, tu.prompts)
+
+	// echoed and confirmed
+	tu.prompts = nil
+	tu.answers = []string{"visible", "visible"}
+	assert.Nil(box.SetVariable("masked", "OTHER", ValueOptions{Echo: true, Confirm: true}, VarMetadata{}))
+	assert.Equal([]testPrompt{{"value: ", false}, {"confirm value: ", false}}, tu.prompts)
+
+	// mismatched confirmation
+	tu.answers = []string{"one", "two"}
+	assert.NotNil(box.AddVariable("mismatch", "", ValueOptions{Confirm: true}, VarMetadata{}, nil, false))
+
+	vars, err := box.LoadEnvVars(testKey)
+	assert.Nil(err)
+	assert.Len(vars, 1)
+	assert.Equal(map[string]string{"masked": "secret", "OTHER": "visible"}, vars["masked"].Vars)
+}
+
+func TestNonInteractiveAdd(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	assert.Nil(box.StoreKey(testKey))
+
+	box.Reader = bytes.NewBufferString("from stdin\n")
+	assert.Nil(box.AddVariable("stdin", "", ValueOptions{Stdin: true}, VarMetadata{}, nil, false))
+
+	tu.Setenv("CI_TOKEN", "from env")
+	assert.Nil(box.AddVariable("env", "TOKEN", ValueOptions{FromEnv: "CI_TOKEN"}, VarMetadata{}, nil, false))
+	assert.NotNil(box.AddVariable("unset", "", ValueOptions{FromEnv: "NOT_SET"}, VarMetadata{}, nil, false))
+
+	idFile := filepath.Join(tu.testSystem.homePath, "id")
+	assert.Nil(ioutil.WriteFile(idFile, []byte("id\n"), 0600))
+	box.Reader = bytes.NewBufferString("secret")
+	assert.Nil(box.AddVariable("aws", "", ValueOptions{}, VarMetadata{}, []string{"AWS_ID=@" + idFile, "AWS_SECRET=-"}, false))
+
+	assert.NotNil(box.AddVariable("literal", "", ValueOptions{}, VarMetadata{}, []string{"KEY=value"}, false))
+	assert.NotNil(box.AddVariable("badname", "", ValueOptions{}, VarMetadata{}, []string{"A: B=@" + idFile}, false))
+	assert.NotNil(box.AddVariable("bad-name", "", ValueOptions{FromEnv: "CI_TOKEN"}, VarMetadata{}, nil, false))
+	assert.NotNil(box.AddVariable("twostdin", "", ValueOptions{}, VarMetadata{}, []string{"A=-", "B=-"}, false))
+	assert.NotNil(box.AddVariable("mixed", "", ValueOptions{Stdin: true}, VarMetadata{}, []string{"A=-"}, false))
+
+	assert.Empty(tu.prompts)
+
+	vars, err := box.LoadEnvVars(testKey)
+	assert.Nil(err)
+	assert.Len(vars, 3)
+	assert.Equal(map[string]string{"stdin": "from stdin"}, vars["stdin"].Vars)
+	assert.Equal(map[string]string{"TOKEN": "from env"}, vars["env"].Vars)
+	assert.Equal(map[string]string{"AWS_ID": "id", "AWS_SECRET": "secret"}, vars["aws"].Vars)
+
+	assert.Equal(sourceStdin, vars["stdin"].Source)
+	assert.Equal(sourceEnv, vars["env"].Source)
+	assert.Equal(sourceMixed, vars["aws"].Source)
+}
+
+func TestRawValues(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	assert.Nil(box.StoreKey(testKey))
+
+	pem := "-----BEGIN KEY-----\nabc\n-----END KEY-----\n\n"
+	pemFile := filepath.Join(tu.testSystem.homePath, "key.pem")
+	assert.Nil(ioutil.WriteFile(pemFile, []byte(pem), 0600))
+	assert.Nil(box.AddVariable("pem", "KEY", ValueOptions{File: pemFile, Raw: true}, VarMetadata{}, nil, false))
+
+	binary := "\xff\xfe\x01 binary"
+	box.Reader = bytes.NewBufferString(binary)
+	assert.Nil(box.SetVariable("pem", "BIN", ValueOptions{Stdin: true, Raw: true}, VarMetadata{}))
+	assert.Nil(box.RenameKey("pem", "KEY", "PEM"))
+
+	vars, err := box.LoadEnvVars(testKey)
+	assert.Nil(err)
+	values, err := vars["pem"].Values()
+	assert.Nil(err)
+	assert.Equal(map[string]string{"PEM": pem, "BIN": binary}, values)
+	assert.Equal(map[string]string{"PEM": encodingBase64, "BIN": encodingBase64}, vars["pem"].Encodings)
+
+	var out bytes.Buffer
+	box.Writer = &out
+	assert.Nil(box.ExportVariable("pem", "dotenv"))
+	assert.NotNil(box.ExportVariable("pem", "json"))
+
+	// storing a plain value drops the encoding
+	assert.Nil(box.SetVariable("pem", "BIN", ValueOptions{File: pemFile}, VarMetadata{}))
+	vars, _ = box.LoadEnvVars(testKey)
+	assert.Equal(map[string]string{"PEM": encodingBase64}, vars["pem"].Encodings)
+	assert.Equal(strings.TrimSpace(pem), vars["pem"].Vars["BIN"])
+}
+
+func TestRunWithFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	assert.Nil(box.StoreKey(testKey))
+	assert.Nil(storeTestVar(box, "kube", map[string]string{"KUBECONFIG": "apiVersion: v1\n", "OTHER": "other"}))
+
+	runtimeDir := filepath.Join(tu.testSystem.homePath, "runtime")
+	assert.Nil(os.Mkdir(runtimeDir, 0700))
+	tu.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+
+	var secretPath string
+	tu.onRun = func(command string, args, env []string, stdout, stderr io.Writer) ExitStatus {
+		for _, e := range env {
+			if strings.HasPrefix(e, "KUBECONFIG=") {
+				secretPath = strings.TrimPrefix(e, "KUBECONFIG=")
+			}
+		}
+		assert.Contains(env, "OTHER=other")
+
+		data, err := ioutil.ReadFile(secretPath)
+		assert.Nil(err)
+		assert.Equal("apiVersion: v1\n", string(data))
+
+		info, err := os.Stat(secretPath)
+		assert.Nil(err)
+		assert.Equal(os.FileMode(0600), info.Mode().Perm())
+		return ExitStatus{Code: 3}
+	}
+
+	assert.Nil(box.RunCommandWithEnv([]string{"kube"}, RunOptions{AsFiles: []string{"KUBECONFIG"}}, []string{"kubectl", "get", "pods"}))
+	assert.Equal(ExitStatus{Code: 3}, *tu.exitStatus)
+	assert.True(strings.HasPrefix(secretPath, runtimeDir))
+
+	_, err := os.Stat(secretPath)
+	assert.True(os.IsNotExist(err))
+
+	assert.NotNil(box.RunCommandWithEnv([]string{"kube"}, RunOptions{AsFiles: []string{"MISSING"}}, []string{"true"}))
+}
+
+func TestRunRedacted(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	var out bytes.Buffer
+	box.Writer = &out
+
+	assert.Nil(box.StoreKey(testKey))
+	assert.Nil(storeTestVar(box, "api", map[string]string{"TOKEN": "tok:en-1234", "PIN": "42"}))
+
+	tu.onRun = func(command string, args, env []string, stdout, stderr io.Writer) ExitStatus {
+		io.WriteString(stdout, "token is tok:e")
+		io.WriteString(stdout, "n-1234, pin is 42\n")
+		io.WriteString(stdout, "basic dG9rOmVuLTEyMzQ= query tok%3Aen-1234\n")
+		return ExitStatus{}
+	}
+
+	assert.Nil(box.RunCommandWithEnv([]string{"api"}, RunOptions{Redact: true}, []string{"curl"}))
+	assert.Equal("token is ****, pin is 42\nbasic dG9rOmVuLTEyMzQ= query tok%3Aen-1234\n", out.String())
+	assert.NotNil(tu.exitStatus)
+
+	out.Reset()
+	assert.Nil(box.RunCommandWithEnv([]string{"api"}, RunOptions{RedactEncoded: true}, []string{"curl"}))
+	assert.Equal("token is ****, pin is 42\nbasic **** query ****\n", out.String())
+}
+
+func TestRunStrict(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	assert.Nil(box.StoreKey(testKey))
+	assert.Nil(storeTestVar(box, "dev", map[string]string{"DB_PASS": "dev", "DEV_ONLY": "yes"}))
+	assert.Nil(storeTestVar(box, "prod", map[string]string{"DB_PASS": "prod"}))
+
+	env, err := tu.runEnv(box, []string{"dev", "missing"}, RunOptions{})
+	assert.Contains(err.Error(), "unable to find missing")
+	assert.Nil(env)
+
+	env, err = tu.runEnv(box, []string{"dev", "missing"}, RunOptions{AllowMissing: true})
+	assert.Nil(err)
+	assert.Contains(env, "DB_PASS=dev")
+
+	env, err = tu.runEnv(box, []string{"dev", "prod"}, RunOptions{})
+	assert.Contains(err.Error(), "DB_PASS is exposed by both dev and prod")
+	assert.Nil(env)
+
+	env, err = tu.runEnv(box, []string{"dev", "prod"}, RunOptions{Override: true})
+	assert.Nil(err)
+	assert.Contains(env, "DB_PASS=prod")
+	assert.NotContains(env, "DB_PASS=dev")
+	assert.Contains(env, "DEV_ONLY=yes")
+
+	// naming a group twice isn't a conflict
+	_, err = tu.runEnv(box, []string{"dev", "dev"}, RunOptions{})
+	assert.Nil(err)
+}
+
+func TestRunHostEnv(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	assert.Nil(box.StoreKey(testKey))
+	assert.Nil(storeTestVar(box, "api", map[string]string{"API_TOKEN": "secret"}))
+
+	tu.Setenv("PATH", "/usr/bin")
+	tu.Setenv("TERM", "xterm")
+	tu.Setenv("AWS_PROFILE", "prod")
+	tu.Setenv("AWS_SECRET_ACCESS_KEY", "aws-secret")
+	tu.Setenv("API_TOKEN", "host token")
+
+	run := func(hostEnv EnvFilter) []string {
+		env, err := tu.runEnv(box, []string{"api"}, RunOptions{HostEnv: hostEnv})
+		assert.Nil(err)
+		return env
+	}
+
+	env := run(EnvFilter{})
+	assert.Contains(env, "AWS_PROFILE=prod")
+	assert.Contains(env, "API_TOKEN=secret")
+	assert.NotContains(env, "API_TOKEN=host token")
+
+	env = run(EnvFilter{Clean: true})
+	assert.Equal([]string{"HOME=" + tu.homePath, "PATH=/usr/bin", "TERM=xterm", "API_TOKEN=secret"}, env)
+
+	env = run(EnvFilter{Keep: []string{"AWS_*"}, Drop: []string{"*SECRET*"}})
+	assert.Contains(env, "AWS_PROFILE=prod")
+	assert.Contains(env, "PATH=/usr/bin")
+	assert.NotContains(env, "AWS_SECRET_ACCESS_KEY=aws-secret")
+	assert.Contains(env, "API_TOKEN=secret")
+
+	env = run(EnvFilter{Drop: []string{"AWS_*"}})
+	assert.NotContains(env, "AWS_PROFILE=prod")
+	assert.Contains(env, "TERM=xterm")
+
+	assert.NotNil(box.RunCommandWithEnv([]string{"api"}, RunOptions{HostEnv: EnvFilter{Keep: []string{"["}}}, []string{"true"}))
+}
+
+func TestRunMappings(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	assert.Nil(box.StoreKey(testKey))
+	assert.Nil(storeTestVar(box, "github", map[string]string{"GITHUB_TOKEN": "ghp_abc", "GITHUB_USER": "me"}))
+	assert.Nil(storeTestVar(box, "tf", map[string]string{"region": "us-east-1"}))
+
+	env, err := tu.runEnv(box, []string{"github:GH_TOKEN=GITHUB_TOKEN,HOMEBREW_GITHUB_API_TOKEN=GITHUB_TOKEN"}, RunOptions{})
+	assert.Nil(err)
+	assert.Contains(env, "GH_TOKEN=ghp_abc")
+	assert.Contains(env, "HOMEBREW_GITHUB_API_TOKEN=ghp_abc")
+	assert.Contains(env, "GITHUB_USER=me")
+	assert.NotContains(env, "GITHUB_TOKEN=ghp_abc")
+
+	// a group given twice gets the mappings from both
+	env, err = tu.runEnv(box, []string{"github:GH_TOKEN=GITHUB_TOKEN", "github:HOMEBREW_GITHUB_API_TOKEN=GITHUB_TOKEN"}, RunOptions{})
+	assert.Nil(err)
+	assert.Contains(env, "GH_TOKEN=ghp_abc")
+	assert.Contains(env, "HOMEBREW_GITHUB_API_TOKEN=ghp_abc")
+
+	_, err = tu.runEnv(box, []string{"github", "github:GH_TOKEN=GITHUB_TOKEN"}, RunOptions{})
+	assert.NotNil(err)
+
+	env, err = tu.runEnv(box, []string{"tf"}, RunOptions{Prefix: "TF_VAR_"})
+	assert.Nil(err)
+	assert.Contains(env, "TF_VAR_region=us-east-1")
+
+	// the stored group is unchanged
+	env, err = tu.runEnv(box, []string{"github"}, RunOptions{})
+	assert.Nil(err)
+	assert.Contains(env, "GITHUB_TOKEN=ghp_abc")
+
+	for _, varName := range []string{"github:GH_TOKEN=MISSING", "github:GITHUB_USER=GITHUB_TOKEN", "github:GH_TOKEN="} {
+		_, err = tu.runEnv(box, []string{varName}, RunOptions{})
+		assert.NotNil(err, varName)
+	}
+	_, err = tu.runEnv(box, []string{"tf"}, RunOptions{Prefix: "TF-VAR-"})
+	assert.NotNil(err)
+}
+
+func TestRunSelectors(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	var out bytes.Buffer
+	box.Writer = &out
+
+	assert.Nil(box.StoreKey(testKey))
+	assert.Nil(storeTestVar(box, "aws-prod-east", map[string]string{"AWS_EAST": "east"}))
+	assert.Nil(storeTestVar(box, "aws-prod-west", map[string]string{"AWS_WEST": "west"}))
+	assert.Nil(box.writeEnvVar(testKey, &EnvVar{Name: "deploy-key", Vars: map[string]string{"DEPLOY_KEY": "sekrit"}, Tags: []string{"deploy"}}))
+
+	dryRun := func(varNames []string, runOpts RunOptions) string {
+		out.Reset()
+		runOpts.DryRun = true
+		assert.Nil(box.RunCommandWithEnv(varNames, runOpts, nil))
+		return out.String()
+	}
+
+	assert.Equal("AWS_EAST (from aws-prod-east)\nAWS_WEST (from aws-prod-west)\n", dryRun([]string{"aws-prod-*"}, RunOptions{}))
+	assert.Equal("DEPLOY_KEY (from deploy-key)\n", dryRun(nil, RunOptions{Tags: []string{"deploy"}}))
+	assert.Equal("AWS_EAST (from aws-prod-east)\nAWS_WEST (from aws-prod-west)\nDEPLOY_KEY (from deploy-key, as a file)\n", dryRun(nil, RunOptions{All: true, AsFiles: []string{"DEPLOY_KEY"}}))
+	assert.Equal("AWS_EAST (from aws-prod-east)\nEAST (from deploy-key)\n", dryRun([]string{"aws-*-east", "deploy-*:EAST=DEPLOY_KEY"}, RunOptions{}))
+
+	// mappings from each -e for a group are combined
+	assert.Equal("A (from deploy-key)\nB (from deploy-key)\n", dryRun([]string{"deploy-key:A=DEPLOY_KEY", "deploy-key:B=DEPLOY_KEY", "deploy-key:A=DEPLOY_KEY"}, RunOptions{}))
+	assert.NotNil(box.RunCommandWithEnv([]string{"deploy-key", "deploy-*:A=DEPLOY_KEY"}, RunOptions{DryRun: true}, nil))
+
+	// a group selected again by tag keeps its mappings
+	assert.Equal("KEY (from deploy-key)\n", dryRun([]string{"deploy-key:KEY=DEPLOY_KEY"}, RunOptions{Tags: []string{"deploy"}}))
+
+	assert.NotNil(box.RunCommandWithEnv(nil, RunOptions{DryRun: true}, nil))
+	assert.NotNil(box.RunCommandWithEnv([]string{"gcp-*"}, RunOptions{DryRun: true}, nil))
+	assert.NotNil(box.RunCommandWithEnv(nil, RunOptions{DryRun: true, Tags: []string{"nope"}}, nil))
+	assert.NotNil(box.RunCommandWithEnv([]string{"aws-prod-east"}, RunOptions{}, nil))
+}
+
+func TestMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	box, tu := newTestBox()
+	defer tu.cleanup()
+
+	var out bytes.Buffer
+	box.Writer = &out
+
+	assert.Nil(box.StoreKey(testKey))
+
+	valueFile := filepath.Join(tu.homePath, "value")
+	assert.Nil(ioutil.WriteFile(valueFile, []byte("secret"), 0600))
+
+	meta := VarMetadata{Description: "deploys the site", Tags: []string{"deploy", "web"}}
+	assert.Nil(box.AddVariable("site", "SITE_TOKEN", ValueOptions{File: valueFile}, meta, nil, false))
+
+	key, _ := box.ReadKey()
+	vars, err := box.LoadEnvVars(key)
+	assert.Nil(err)
+	site := vars["site"]
+	assert.Equal("deploys the site", site.Description)
+	assert.Equal([]string{"deploy", "web"}, site.Tags)
+	assert.Equal(sourceFile, site.Source)
+	assert.NotNil(site.Created)
+	assert.Equal(site.Created, site.Updated)
+
+	// only metadata changes, without prompting for a value
+	assert.Nil(box.SetVariable("site", "", ValueOptions{}, VarMetadata{Tags: []string{"prod"}, Untag: []string{"web"}}))
+	vars, _ = box.LoadEnvVars(key)
+	assert.Equal([]string{"deploy", "prod"}, vars["site"].Tags)
+	assert.Equal(map[string]string{"SITE_TOKEN": "secret"}, vars["site"].Vars)
+	assert.Equal(*site.Created, *vars["site"].Created)
+	assert.False(vars["site"].Updated.Before(*site.Updated))
+
+	tu.Setenv("CI_TOKEN", "from ci")
+	assert.Nil(box.SetVariable("site", "SITE_TOKEN", ValueOptions{FromEnv: "CI_TOKEN"}, VarMetadata{}))
+	vars, _ = box.LoadEnvVars(key)
+	assert.Equal(sourceEnv, vars["site"].Source)
+
+	// values prompted for after the first don't share its source
+	tu.answers = []string{"EXTRA", "typed", ""}
+	assert.Nil(box.AddVariable("multi", "FIRST", ValueOptions{FromEnv: "CI_TOKEN"}, VarMetadata{}, nil, true))
+	vars, _ = box.LoadEnvVars(key)
+	assert.Equal(map[string]string{"FIRST": "from ci", "EXTRA": "typed"}, vars["multi"].Vars)
+	assert.Equal(sourceMixed, vars["multi"].Source)
+
+	assert.NotNil(box.SetVariable("site", "", ValueOptions{}, VarMetadata{Untag: []string{"missing"}}))
+	assert.NotNil(box.SetVariable("site", "", ValueOptions{}, VarMetadata{Tags: []string{"two words"}}))
+
+	// bad metadata is rejected before a value is prompted for
+	tu.answers = []string{"typed secret"}
+	assert.NotNil(box.AddVariable("badtag", "", ValueOptions{}, VarMetadata{Tags: []string{"bad tag"}}, nil, false))
+	assert.NotNil(box.SetVariable("site", "SITE_TOKEN", ValueOptions{}, VarMetadata{Description: "two\nlines"}))
+	assert.Equal([]string{"typed secret"}, tu.answers)
+
+	// groups stored before metadata existed still load
+	assert.Nil(storeLegacyTestVar(box))
+
+	out.Reset()
+	assert.Nil(box.ListVariables(VarFilter{}, false, false))
+	assert.Contains(out.String(), "site: SITE_TOKEN [deploy, prod] - deploys the site\n")
+
+	out.Reset()
+	assert.Nil(box.ShowVariable("site", false))
+	assert.Contains(out.String(), "description: deploys the site\ntags: deploy, prod\nsource: env\ncreated: ")
+
+	out.Reset()
+	assert.Nil(box.ShowVariable("old", false))
+	assert.NotContains(out.String(), "created")
+
+	var summary varSummary
+	out.Reset()
+	assert.Nil(box.ShowVariable("site", true))
+	assert.Nil(json.Unmarshal(out.Bytes(), &summary))
+	assert.Equal("deploys the site", summary.Description)
+	assert.NotNil(summary.Updated)
+}
@@ -2,16 +2,22 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+
+	"golang.org/x/crypto/nacl/secretbox"
 )
 
+// testKey is a valid key for use in tests.
+const testKey = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
 // testBoxUtils is a handy handle to all of the testing implementations of
 // interfaces needed by EnvBox.
 type testBoxUtils struct {
 	*testSystem
-	// more to come
+	*testPrompter
 }
 
 // cleanup will clean up any testing resources created
@@ -25,18 +31,109 @@ func newTestBox() (*EnvBox, *testBoxUtils) {
 	box, _ := NewEnvBox()
 
 	tu := &testBoxUtils{
-		testSystem: newTestSystem(),
+		testSystem:   newTestSystem(),
+		testPrompter: &testPrompter{},
 	}
 
 	box.System = tu.testSystem
+	box.Prompter = tu.testPrompter
 
 	return box, tu
 }
 
+// runEnv runs a supervised command with the named groups and returns the
+// environment it was given, which is nil if it wasn't run.
+func (tu *testBoxUtils) runEnv(box *EnvBox, varNames []string, runOpts RunOptions) ([]string, error) {
+	tu.lastEnv = nil
+	runOpts.Supervise = true
+	err := box.RunCommandWithEnv(varNames, runOpts, []string{"true"})
+	return tu.lastEnv, err
+}
+
+// storeTestVar stores a variable group directly, bypassing any prompting.
+func storeTestVar(box *EnvBox, name string, vars map[string]string) error {
+	return box.writeEnvVar(testKey, &EnvVar{Name: name, Vars: vars})
+}
+
+// storeLegacyTestVar stores a variable group named "old" the way early
+// versions of envbox did: no file header, the single variable layout and the
+// first 32 characters of the key used directly.
+func storeLegacyTestVar(box *EnvBox) error {
+	var legacyKey [32]byte
+	copy(legacyKey[:], testKey[:32])
+	var nonce [24]byte
+	sealed := secretbox.Seal(nonce[:], []byte(`{"name":"old","exposed":"OLD","value":"old"}`), &nonce, &legacyKey)
+
+	dataPath, err := box.DataPath()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dataPath, "old.envenc"), sealed, 0600)
+}
+
+// testPrompter is a testing implementation of the Prompter interface.  It
+// answers prompts from a list and records how each prompt was made.
+type testPrompter struct {
+	answers []string
+	prompts []testPrompt
+}
+
+type testPrompt struct {
+	prompt string
+	masked bool
+}
+
+func (tp *testPrompter) answer(prompt string, masked bool) (string, error) {
+	tp.prompts = append(tp.prompts, testPrompt{prompt, masked})
+
+	if len(tp.answers) == 0 {
+		return "", fmt.Errorf("no answer for %q", prompt)
+	}
+
+	answer := tp.answers[0]
+	tp.answers = tp.answers[1:]
+	return answer, nil
+}
+
+func (tp *testPrompter) PromptMasked(prompt string) (string, error) {
+	return tp.answer(prompt, true)
+}
+
+func (tp *testPrompter) PromptFor(prompt string) (string, error) {
+	return tp.answer(prompt, false)
+}
+
+func (tp *testPrompter) PromptConfirmed(prompt string, masked bool) (string, error) {
+	value, err := tp.answer(prompt, masked)
+	if err != nil {
+		return "", err
+	}
+
+	again, err := tp.answer("confirm "+prompt, masked)
+	if err != nil {
+		return "", err
+	}
+
+	if value != again {
+		return "", fmt.Errorf("values do not match")
+	}
+	return value, nil
+}
+
 // testSystem is a testing implementation of the System interface.
 type testSystem struct {
 	homePath string
 	Env      map[string]string
+
+	// onRun is called in place of running a supervised command, and returns
+	// how it finished.
+	onRun func(command string, args, env []string, stdout, stderr io.Writer) ExitStatus
+
+	// exitStatus is set when Exit is called.
+	exitStatus *ExitStatus
+
+	// lastEnv is the environment the last supervised command was run with.
+	lastEnv []string
 }
 
 func newTestSystem() *testSystem {
@@ -57,6 +154,14 @@ func (ts testSystem) Getenv(key string) string {
 	return ""
 }
 
+func (ts testSystem) Environ() []string {
+	var environ []string
+	for _, key := range sortedKeys(ts.Env) {
+		environ = append(environ, fmt.Sprintf("%s=%s", key, ts.Env[key]))
+	}
+	return environ
+}
+
 func (ts *testSystem) Setenv(key, value string) {
 	ts.Env[key] = value
 }
@@ -91,3 +196,29 @@ func (ts testSystem) DataPath() (string, error) {
 func (ts testSystem) ExecCommandWithEnv(command string, args []string, extraEnv []string) error {
 	return nil
 }
+
+func (ts *testSystem) SuperviseCommandWithEnv(command string, args []string, extraEnv []string, stdout, stderr io.Writer) (ExitStatus, error) {
+	ts.lastEnv = extraEnv
+	if ts.onRun == nil {
+		return ExitStatus{}, nil
+	}
+	return ts.onRun(command, args, extraEnv, stdout, stderr), nil
+}
+
+// The credential helper is never found, so tests can't touch a real key
+// stored with one.
+func (ts testSystem) GetCredHelperKey() (string, error) {
+	return "", helperNotFound
+}
+
+func (ts testSystem) StoreCredHelperKey(key string) error {
+	return helperNotFound
+}
+
+func (ts testSystem) ClearCredHelperKey() error {
+	return helperNotFound
+}
+
+func (ts *testSystem) Exit(status ExitStatus) {
+	ts.exitStatus = &status
+}
@@ -19,7 +19,7 @@ func GetCredHelperKey() (string, error) {
 		return "", helperNotFound
 	}
 
-	logrus.Debugf("found cred helper instance %x", cr)
+	logrus.Debugf("found cred helper instance %p", cr)
 	creds, err := cr.Get(url)
 	if err != nil {
 		return "", err
@@ -38,8 +38,8 @@ func StoreCredHelperKey(keys string) error {
 		return helperNotFound
 	}
 
-	logrus.Debugf("found cred helper instance %x", cr)
-	creds := &credentials.Credentials{url, "key", keys}
+	logrus.Debugf("found cred helper instance %p", cr)
+	creds := &credentials.Credentials{ServerURL: url, Username: "key", Secret: keys}
 	err = cr.Store(creds)
 	if err != nil {
 		return err
@@ -58,7 +58,7 @@ func ClearCredHelperKey() error {
 		return helperNotFound
 	}
 
-	logrus.Debugf("found cred helper instance %x", cr)
+	logrus.Debugf("found cred helper instance %p", cr)
 	err = cr.Erase(url)
 	if err != nil {
 		return err
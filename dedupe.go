@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+type DedupeCommand struct {
+	Newest bool `long:"newest" description:"Keep the most recently written file without prompting."`
+}
+
+var dedupeCommand DedupeCommand
+
+func (c *DedupeCommand) Execute(args []string) error {
+	box, err := NewEnvBox()
+	if err != nil {
+		return errors.Wrap(err, "unable to create env box")
+	}
+
+	return box.Dedupe(c.Newest)
+}
+
+func init() {
+	_, err := parser.AddCommand("dedupe", "Resolve variables stored in more than one file.", "", &dedupeCommand)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+type DoctorCommand struct {
+	Quarantine bool `long:"quarantine" description:"Move files that can't be loaded into the quarantine directory."`
+}
+
+var doctorCommand DoctorCommand
+
+func (c *DoctorCommand) Execute(args []string) error {
+	box, err := NewEnvBox()
+	if err != nil {
+		return errors.Wrap(err, "unable to create env box")
+	}
+
+	return box.CheckDataFiles(c.Quarantine)
+}
+
+func init() {
+	cmd, err := parser.AddCommand("doctor", "Check stored files for problems.", "", &doctorCommand)
+
+	cmd.Aliases = append(cmd.Aliases, "fsck")
+
+	if err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseDotenv parses the contents of a .env file.  It supports comments, an
+// optional "export" prefix, unquoted values, single quoted values (taken
+// literally) and double quoted values (with backslash escapes).  Quoted values
+// may span multiple lines.  Variable references are not expanded.
+func parseDotenv(data string) (map[string]string, error) {
+	vars := make(map[string]string)
+
+	data = strings.Replace(data, "\r\n", "\n", -1)
+	lineNum := 0
+	rest := data
+
+	for len(rest) > 0 {
+		var line string
+		if i := strings.IndexByte(rest, '\n'); i >= 0 {
+			line, rest = rest[:i], rest[i+1:]
+		} else {
+			line, rest = rest, ""
+		}
+		lineNum++
+
+		// only trim the left, trailing space may be part of a quoted value
+		line = strings.TrimLeft(line, " \t")
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "export ") || strings.HasPrefix(line, "export\t") {
+			line = strings.TrimSpace(line[len("export"):])
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected NAME=value", lineNum)
+		}
+
+		name := strings.TrimSpace(line[:eq])
+		if !envNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("line %d: invalid name %q", lineNum, name)
+		}
+
+		value := strings.TrimLeft(line[eq+1:], " \t")
+		if len(value) > 0 && (value[0] == '\'' || value[0] == '"') {
+			// quoted values may continue onto following lines, so parse from
+			// the raw remaining input
+			quoted := value + "\n" + rest
+			if len(rest) == 0 {
+				quoted = value
+			}
+
+			parsed, consumed, err := parseQuoted(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNum, err)
+			}
+
+			lineNum += strings.Count(quoted[:consumed], "\n")
+			after := quoted[consumed:]
+			if i := strings.IndexByte(after, '\n'); i >= 0 {
+				after, rest = after[:i], after[i+1:]
+			} else {
+				rest = ""
+			}
+
+			after = strings.TrimSpace(after)
+			if len(after) > 0 && !strings.HasPrefix(after, "#") {
+				return nil, fmt.Errorf("line %d: unexpected text after quoted value", lineNum)
+			}
+
+			value = parsed
+		} else {
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = value[:i]
+			} else if i := strings.Index(value, "\t#"); i >= 0 {
+				value = value[:i]
+			}
+			value = strings.TrimSpace(value)
+		}
+
+		vars[name] = value
+	}
+
+	return vars, nil
+}
+
+// parseQuoted parses a single or double quoted value at the start of s,
+// returning the value and the number of bytes consumed.
+func parseQuoted(s string) (string, int, error) {
+	quote := s[0]
+
+	if quote == '\'' {
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", 0, fmt.Errorf("unterminated single quote")
+		}
+		return s[1 : end+1], end + 2, nil
+	}
+
+	var value []byte
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return string(value), i + 1, nil
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				value = append(value, '\n')
+			case 'r':
+				value = append(value, '\r')
+			case 't':
+				value = append(value, '\t')
+			case '"', '\\', '$', '`':
+				value = append(value, s[i])
+			default:
+				value = append(value, '\\', s[i])
+			}
+		default:
+			value = append(value, c)
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated double quote")
+}
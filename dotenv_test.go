@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDotenv(t *testing.T) {
+	assert := assert.New(t)
+
+	vars, err := parseDotenv(`# a comment
+PLAIN=value
+export EXPORTED=exported
+SPACED = spaced value   # trailing comment
+HASH=pa#ss
+EMPTY=
+SINGLE='$HOME \n "x" '
+DOUBLE="line1\nline2 \"quoted\" \$HOME"
+MULTI="first` + "  \n" + `second"
+MULTI_SINGLE='-----BEGIN KEY-----
+abc
+-----END KEY-----' # pem
+AFTER=after
+`)
+	assert.Nil(err)
+	assert.Equal(map[string]string{
+		"PLAIN":        "value",
+		"EXPORTED":     "exported",
+		"SPACED":       "spaced value",
+		"HASH":         "pa#ss",
+		"EMPTY":        "",
+		"SINGLE":       "$HOME \\n \"x\" ",
+		"DOUBLE":       "line1\nline2 \"quoted\" $HOME",
+		"MULTI":        "first  \nsecond",
+		"MULTI_SINGLE": "-----BEGIN KEY-----\nabc\n-----END KEY-----",
+		"AFTER":        "after",
+	}, vars)
+}
+
+func TestParseDotenvErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, input := range []string{
+		"NOEQUALS",
+		"BAD-NAME=value",
+		"OPEN=\"unterminated",
+		"OPEN='unterminated",
+		"TRAILING=\"value\" junk",
+	} {
+		_, err := parseDotenv(input)
+		assert.NotNil(err, input)
+	}
+}
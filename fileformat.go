@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Data files start with a small header:
+//
+//	magic    4 bytes  "EVBX"
+//	version  1 byte   format version, currently 1
+//	kdf      1 byte   how the cipher key was derived from the stored key
+//	cipher   1 byte   cipher used, currently always NaCl secretbox
+//	key id   8 bytes  identifies the key the file was sealed with
+//
+// followed by the nonce and the sealed data.  The header is also prepended to
+// the plaintext before sealing and checked after opening, so it can't be
+// altered without detection.
+//
+// Files written before the header was introduced are just the nonce followed
+// by the sealed data, and are still opened by trying each possible key.
+var fileMagic = []byte("EVBX")
+
+const (
+	fileVersion     byte = 1
+	cipherSecretbox byte = 1
+	keyIDLength          = 8
+	headerLength         = 4 + 3 + keyIDLength
+	nonceLength          = 24
+)
+
+var errTruncated = fmt.Errorf("file too short")
+var errWrongKey = fmt.Errorf("unable to decrypt, wrong key")
+var errCorrupt = fmt.Errorf("unable to decrypt, file is corrupt")
+var errUnrecognized = fmt.Errorf("not an envbox file, or a legacy file sealed with another key")
+var errBadJSON = fmt.Errorf("unable to decode")
+
+// fileHeader is the parsed form of a data file header.
+type fileHeader struct {
+	Version byte
+	KDF     byte
+	Cipher  byte
+	KeyID   []byte
+}
+
+func (h fileHeader) bytes() []byte {
+	out := make([]byte, 0, headerLength)
+	out = append(out, fileMagic...)
+	out = append(out, h.Version, h.KDF, h.Cipher)
+	return append(out, h.KeyID...)
+}
+
+// parseHeader parses the header at the start of data, returning false if the
+// data doesn't start with one.
+func parseHeader(data []byte) (fileHeader, bool) {
+	if len(data) < headerLength || !bytes.HasPrefix(data, fileMagic) {
+		return fileHeader{}, false
+	}
+
+	rest := data[len(fileMagic):]
+	return fileHeader{
+		Version: rest[0],
+		KDF:     rest[1],
+		Cipher:  rest[2],
+		KeyID:   rest[3 : 3+keyIDLength],
+	}, true
+}
+
+// sealEnvVar encrypts an EnvVar with the key, returning the header, nonce and
+// sealed JSON data.
+func sealEnvVar(key string, envVar EnvVar) ([]byte, error) {
+	envVar.LegacyExposed = ""
+	envVar.LegacyValue = ""
+
+	message, err := json.Marshal(envVar)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := cipherKeys(key)
+	if err != nil {
+		return nil, err
+	}
+	ck := keys[0]
+
+	header := fileHeader{
+		Version: fileVersion,
+		KDF:     ck.kdf,
+		Cipher:  cipherSecretbox,
+		KeyID:   ck.id(),
+	}.bytes()
+
+	var nonce [nonceLength]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, errors.Wrap(err, "unable to read random")
+	}
+
+	out := make([]byte, 0, headerLength+nonceLength+headerLength+len(message)+secretbox.Overhead)
+	out = append(out, header...)
+	out = append(out, nonce[:]...)
+
+	return secretbox.Seal(out, append(header, message...), &nonce, ck.key), nil
+}
+
+// openEnvVar decrypts data sealed by sealEnvVar, converting the legacy single
+// variable format to the Vars map.
+func openEnvVar(key string, data []byte) (EnvVar, error) {
+	var envVar EnvVar
+
+	keys, err := cipherKeys(key)
+	if err != nil {
+		return envVar, err
+	}
+
+	var message []byte
+	if header, ok := parseHeader(data); ok {
+		message, err = openWithHeader(keys, header, data)
+	} else {
+		message, err = openHeaderless(keys, data)
+	}
+	if err != nil {
+		return envVar, err
+	}
+
+	if err := json.Unmarshal(message, &envVar); err != nil {
+		return envVar, errBadJSON
+	}
+
+	if len(envVar.LegacyExposed) > 0 {
+		envVar.Vars = map[string]string{envVar.LegacyExposed: envVar.LegacyValue}
+	}
+
+	return envVar, nil
+}
+
+// openWithHeader opens a file with a header, returning the JSON data.
+func openWithHeader(keys []cipherKey, header fileHeader, data []byte) ([]byte, error) {
+	if header.Version != fileVersion {
+		return nil, fmt.Errorf("unsupported file version %d", header.Version)
+	}
+	if header.Cipher != cipherSecretbox {
+		return nil, fmt.Errorf("unsupported cipher %d", header.Cipher)
+	}
+
+	var ck *cipherKey
+	for i := range keys {
+		if keys[i].kdf == header.KDF {
+			ck = &keys[i]
+		}
+	}
+	if ck == nil {
+		return nil, errWrongKey
+	}
+	if !bytes.Equal(ck.id(), header.KeyID) {
+		return nil, errWrongKey
+	}
+
+	if len(data) < headerLength+nonceLength+secretbox.Overhead {
+		return nil, errTruncated
+	}
+
+	var nonce [nonceLength]byte
+	copy(nonce[:], data[headerLength:])
+
+	message, ok := secretbox.Open(nil, data[headerLength+nonceLength:], &nonce, ck.key)
+	if !ok {
+		return nil, errCorrupt
+	}
+
+	if !bytes.HasPrefix(message, data[:headerLength]) {
+		return nil, errCorrupt
+	}
+
+	return message[headerLength:], nil
+}
+
+// openHeaderless opens a file written before headers were introduced,
+// returning the JSON data.
+func openHeaderless(keys []cipherKey, data []byte) ([]byte, error) {
+	if len(data) < nonceLength+secretbox.Overhead {
+		return nil, errTruncated
+	}
+
+	var nonce [nonceLength]byte
+	copy(nonce[:], data[:nonceLength])
+
+	for _, ck := range keys {
+		if message, ok := secretbox.Open(nil, data[nonceLength:], &nonce, ck.key); ok {
+			return message, nil
+		}
+	}
+
+	return nil, errUnrecognized
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// VarFilter selects variable groups by name, exposed key or tag.  Empty
+// fields match everything.
+type VarFilter struct {
+	// Pattern is matched against the group name and each exposed key, as a
+	// glob unless Regex is set.
+	Pattern string
+	Regex   bool
+
+	// Key must be exposed by the group, also as a glob or regex.
+	Key string
+
+	// Tag must be one of the group's tags.
+	Tag string
+}
+
+// matcher returns a function matching a string against pattern.
+func (f VarFilter) matcher(pattern string) (func(string) bool, error) {
+	if len(pattern) == 0 {
+		return func(string) bool { return true }, nil
+	}
+
+	if f.Regex {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid pattern")
+		}
+		return re.MatchString, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, errors.Wrap(err, "invalid pattern")
+	}
+	return func(s string) bool {
+		matched, _ := path.Match(pattern, s)
+		return matched
+	}, nil
+}
+
+// Apply returns the groups in vars that match the filter.
+func (f VarFilter) Apply(vars map[string]EnvVar) (map[string]EnvVar, error) {
+	matchPattern, err := f.matcher(f.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	matchKey, err := f.matcher(f.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make(map[string]EnvVar)
+	for name, envVar := range vars {
+		if len(f.Pattern) > 0 && !matchPattern(name) && !anyKey(envVar, matchPattern) {
+			continue
+		}
+		if len(f.Key) > 0 && !anyKey(envVar, matchKey) {
+			continue
+		}
+		if len(f.Tag) > 0 && !hasTag(envVar, f.Tag) {
+			continue
+		}
+		filtered[name] = envVar
+	}
+
+	return filtered, nil
+}
+
+// anyKey reports whether any exposed key in envVar matches.
+func anyKey(envVar EnvVar, match func(string) bool) bool {
+	for k := range envVar.Vars {
+		if match(k) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTag(envVar EnvVar, tag string) bool {
+	for _, t := range envVar.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanEnv lists the host variables kept in a clean environment.
+var cleanEnv = []string{"PATH", "HOME", "TERM"}
+
+// EnvFilter selects which of the host's environment variables a command is
+// run with.  Patterns are globs.
+type EnvFilter struct {
+	// Clean keeps only the variables in cleanEnv.
+	Clean bool
+
+	// Keep adds variables to a clean environment, and implies Clean.
+	Keep []string
+
+	// Drop removes variables, even ones that would otherwise be kept.
+	Drop []string
+}
+
+// Apply returns the variables from environ, in the form "NAME=value", that
+// pass the filter.
+func (f EnvFilter) Apply(environ []string) ([]string, error) {
+	for _, pattern := range append(f.Keep, f.Drop...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern %s", pattern)
+		}
+	}
+
+	clean := f.Clean || len(f.Keep) > 0
+	keep := append(append([]string{}, cleanEnv...), f.Keep...)
+
+	var filtered []string
+	for _, hostVar := range environ {
+		name := strings.SplitN(hostVar, "=", 2)[0]
+		if clean && !matchEnvName(keep, name) {
+			continue
+		}
+		if matchEnvName(f.Drop, name) {
+			continue
+		}
+		filtered = append(filtered, hostVar)
+	}
+
+	return filtered, nil
+}
+
+// matchEnvName checks whether name matches any of patterns.  Names on
+// Windows aren't case sensitive, so neither is matching there.
+func matchEnvName(patterns []string, name string) bool {
+	if runtime.GOOS == "windows" {
+		name = strings.ToUpper(name)
+	}
+
+	for _, pattern := range patterns {
+		if runtime.GOOS == "windows" {
+			pattern = strings.ToUpper(pattern)
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
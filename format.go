@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// exportFormats maps the names accepted by show --format to functions that
+// write variables in that format.
+var exportFormats = map[string]func(io.Writer, map[string]string) error{
+	"sh":         formatShell,
+	"fish":       formatFish,
+	"powershell": formatPowerShell,
+	"dotenv":     formatDotenv,
+	"json":       formatJSON,
+	"yaml":       formatYAML,
+	"docker":     formatDocker,
+	"systemd":    formatSystemd,
+}
+
+// sortedKeys returns the keys of vars in sorted order, so output is stable.
+func sortedKeys(vars map[string]string) []string {
+	var keys []string
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// shellQuote quotes a value for POSIX shells.  Nothing is special inside
+// single quotes, so only single quotes themselves need handling.
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// checkExportable makes sure every name can be used as a variable name and no
+// value contains a NUL byte, which can't be stored in the environment.
+func checkExportable(vars map[string]string) error {
+	for _, k := range sortedKeys(vars) {
+		if !envNamePattern.MatchString(k) {
+			return fmt.Errorf("%q is not a valid variable name", k)
+		}
+		if strings.ContainsRune(vars[k], 0) {
+			return fmt.Errorf("value of %s contains a NUL byte", k)
+		}
+	}
+	return nil
+}
+
+func formatShell(w io.Writer, vars map[string]string) error {
+	if err := checkExportable(vars); err != nil {
+		return err
+	}
+	for _, k := range sortedKeys(vars) {
+		fmt.Fprintf(w, "export %s=%s\n", k, shellQuote(vars[k]))
+	}
+	return nil
+}
+
+func formatFish(w io.Writer, vars map[string]string) error {
+	if err := checkExportable(vars); err != nil {
+		return err
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	for _, k := range sortedKeys(vars) {
+		fmt.Fprintf(w, "set -gx %s '%s'\n", k, replacer.Replace(vars[k]))
+	}
+	return nil
+}
+
+// formatPowerShell writes single quoted strings.  PowerShell treats the curly
+// quotes U+2018 to U+201B as single quotes too, so those are doubled as well.
+func formatPowerShell(w io.Writer, vars map[string]string) error {
+	if err := checkExportable(vars); err != nil {
+		return err
+	}
+	replacer := strings.NewReplacer("'", "''", "\u2018", "\u2018\u2018", "\u2019", "\u2019\u2019", "\u201a", "\u201a\u201a", "\u201b", "\u201b\u201b")
+	for _, k := range sortedKeys(vars) {
+		fmt.Fprintf(w, "$env:%s = '%s'\n", k, replacer.Replace(vars[k]))
+	}
+	return nil
+}
+
+// formatDotenv writes double quoted values, which parseDotenv reads back.
+func formatDotenv(w io.Writer, vars map[string]string) error {
+	if err := checkExportable(vars); err != nil {
+		return err
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "`", "\\`", "\n", `\n`, "\r", `\r`)
+	for _, k := range sortedKeys(vars) {
+		fmt.Fprintf(w, "%s=\"%s\"\n", k, replacer.Replace(vars[k]))
+	}
+	return nil
+}
+
+// checkUTF8 makes sure every value is valid UTF-8, which JSON and YAML
+// strings require.
+func checkUTF8(vars map[string]string) error {
+	for _, k := range sortedKeys(vars) {
+		if !utf8.ValidString(vars[k]) {
+			return fmt.Errorf("value of %s is binary, use show --json for the encoded form", k)
+		}
+	}
+	return nil
+}
+
+func formatJSON(w io.Writer, vars map[string]string) error {
+	if err := checkUTF8(vars); err != nil {
+		return err
+	}
+	return writeJSON(w, vars)
+}
+
+// writeJSON writes v as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// yamlSpecialKeys are variable names that YAML 1.1 reads as booleans or null
+// unless they're quoted.
+var yamlSpecialKeys = map[string]bool{
+	"Y": true, "YES": true, "N": true, "NO": true, "TRUE": true, "FALSE": true,
+	"ON": true, "OFF": true, "NULL": true,
+}
+
+// formatYAML writes each value as a double quoted scalar.  JSON strings are
+// valid YAML double quoted scalars, so encoding/json does the escaping.
+func formatYAML(w io.Writer, vars map[string]string) error {
+	if err := checkExportable(vars); err != nil {
+		return err
+	}
+	if err := checkUTF8(vars); err != nil {
+		return err
+	}
+	for _, k := range sortedKeys(vars) {
+		name := k
+		if yamlSpecialKeys[strings.ToUpper(k)] {
+			name = `"` + k + `"`
+		}
+
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(vars[k]); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s: %s\n", name, strings.TrimSuffix(buf.String(), "\n"))
+	}
+	return nil
+}
+
+// formatDocker writes the format read by docker run --env-file, which takes
+// everything after the = literally and has no way to represent newlines.
+func formatDocker(w io.Writer, vars map[string]string) error {
+	if err := checkExportable(vars); err != nil {
+		return err
+	}
+	for _, k := range sortedKeys(vars) {
+		if strings.ContainsAny(vars[k], "\r\n") {
+			return fmt.Errorf("value of %s contains a newline, which docker env files can't represent", k)
+		}
+	}
+	for _, k := range sortedKeys(vars) {
+		fmt.Fprintf(w, "%s=%s\n", k, vars[k])
+	}
+	return nil
+}
+
+// formatSystemd writes the format read by systemd's EnvironmentFile=.  Inside
+// double quotes, systemd removes the backslash before ", \, ` and $, and keeps
+// newlines as they are.
+func formatSystemd(w io.Writer, vars map[string]string) error {
+	if err := checkExportable(vars); err != nil {
+		return err
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", `$`, `\$`)
+	for _, k := range sortedKeys(vars) {
+		fmt.Fprintf(w, "%s=\"%s\"\n", k, replacer.Replace(vars[k]))
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var trickyVars = map[string]string{
+	"PLAIN":     "plain",
+	"DOLLAR":    "pa$$word $HOME ${HOME}",
+	"QUOTES":    `it's "quoted"`,
+	"BACKSLASH": `C:\path\to\n`,
+	"BACKTICK":  "`id`",
+	"NEWLINES":  "line1\nline2\r\n",
+	"HASH":      "value # not a comment",
+	"EMPTY":     "",
+	"UNICODE":   "snow \u2603",
+	"CURLY":     "it\u2019s \u2018quoted\u2019 \u201alow\u201b",
+}
+
+func TestDotenvRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	assert.Nil(formatDotenv(&buf, trickyVars))
+
+	parsed, err := parseDotenv(buf.String())
+	assert.Nil(err)
+	assert.Equal(trickyVars, parsed)
+}
+
+func TestFormats(t *testing.T) {
+	assert := assert.New(t)
+
+	vars := map[string]string{"B": "it's $x", "A": "a"}
+
+	expected := map[string]string{
+		"sh":         "export A='a'\nexport B='it'\\''s $x'\n",
+		"fish":       "set -gx A 'a'\nset -gx B 'it\\'s $x'\n",
+		"powershell": "$env:A = 'a'\n$env:B = 'it''s $x'\n",
+		"dotenv":     "A=\"a\"\nB=\"it's \\$x\"\n",
+		"yaml":       "A: \"a\"\nB: \"it's $x\"\n",
+		"docker":     "A=a\nB=it's $x\n",
+		"systemd":    "A=\"a\"\nB=\"it's \\$x\"\n",
+	}
+
+	for format, want := range expected {
+		var buf bytes.Buffer
+		assert.Nil(exportFormats[format](&buf, vars), format)
+		assert.Equal(want, buf.String(), format)
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(formatJSON(&buf, trickyVars))
+	var decoded map[string]string
+	assert.Nil(json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(trickyVars, decoded)
+
+	assert.NotNil(formatDocker(&buf, map[string]string{"KEY": "multi\nline"}))
+
+	// curly quotes end PowerShell single quoted strings too
+	buf.Reset()
+	assert.Nil(formatPowerShell(&buf, map[string]string{"C": "x\u2019; rm -rf ~; \u2018\u201a\u201b"}))
+	assert.Equal("$env:C = 'x\u2019\u2019; rm -rf ~; \u2018\u2018\u201a\u201a\u201b\u201b'\n", buf.String())
+
+	buf.Reset()
+	assert.Nil(formatYAML(&buf, map[string]string{"ON": "x", "no": "y"}))
+	assert.Equal("\"ON\": \"x\"\n\"no\": \"y\"\n", buf.String())
+	assert.NotNil(formatYAML(&buf, map[string]string{"A: B": "x"}))
+	assert.NotNil(formatYAML(&buf, map[string]string{"A#B": "x"}))
+}
+
+func TestShellEval(t *testing.T) {
+	assert := assert.New(t)
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	vars := map[string]string{
+		"INJECT":  "$(echo pwned) `echo pwned`; echo pwned",
+		"UNICODE": `\u2603 \x41 \101`,
+		"QUOTES":  `'"'"'`,
+	}
+	for k, v := range trickyVars {
+		vars[k] = v
+	}
+
+	var script bytes.Buffer
+	assert.Nil(formatShell(&script, vars))
+
+	for name, value := range vars {
+		out, err := exec.Command("sh", "-c", script.String()+`printf '%s' "$`+name+`"`).Output()
+		assert.Nil(err, name)
+		assert.Equal(value, string(out), name)
+	}
+}
+
+func TestExportableNames(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	assert.NotNil(formatShell(&buf, map[string]string{"BAD-NAME": "x"}))
+	assert.NotNil(formatShell(&buf, map[string]string{"X; rm -rf ~": "x"}))
+	assert.NotNil(formatShell(&buf, map[string]string{"NUL": "a\x00b"}))
+	assert.Empty(buf.String())
+}
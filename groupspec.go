@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// groupSpec is a variable group named on the run command line, along with
+// any renaming of the keys it exposes, as in "github:GH_TOKEN=GITHUB_TOKEN".
+type groupSpec struct {
+	Name     string
+	Mappings []keyMapping
+}
+
+// keyMapping exposes the value of the stored key From under the name To.
+type keyMapping struct {
+	To   string
+	From string
+}
+
+// parseGroupSpec parses a group name, optionally followed by a colon and a
+// comma separated list of NEW=STORED key mappings.
+func parseGroupSpec(spec string) (groupSpec, error) {
+	colon := strings.LastIndex(spec, ":")
+	if colon < 0 || !strings.Contains(spec[colon+1:], "=") {
+		return groupSpec{Name: spec}, nil
+	}
+
+	gs := groupSpec{Name: spec[:colon]}
+	for _, mapping := range strings.Split(spec[colon+1:], ",") {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 || !envNamePattern.MatchString(parts[0]) || !envNamePattern.MatchString(parts[1]) {
+			return groupSpec{}, fmt.Errorf("invalid mapping %q in %s, expected NEW=STORED", mapping, spec)
+		}
+		gs.Mappings = append(gs.Mappings, keyMapping{To: parts[0], From: parts[1]})
+	}
+
+	return gs, nil
+}
+
+func (gs groupSpec) hasMapping(mapping keyMapping) bool {
+	for _, m := range gs.Mappings {
+		if m == mapping {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns values with the mappings and then prefix applied.  A mapped
+// key is only exposed under its new names.
+func (gs groupSpec) apply(values map[string]string, prefix string) (map[string]string, error) {
+	mapped := make(map[string]bool)
+	for _, mapping := range gs.Mappings {
+		if _, ok := values[mapping.From]; !ok {
+			return nil, fmt.Errorf("unable to map %s, %s doesn't expose it", mapping.From, gs.Name)
+		}
+		mapped[mapping.From] = true
+	}
+
+	exposed := make(map[string]string)
+	for key, value := range values {
+		if !mapped[key] {
+			exposed[key] = value
+		}
+	}
+	for _, mapping := range gs.Mappings {
+		if _, ok := exposed[mapping.To]; ok {
+			return nil, fmt.Errorf("unable to map %s to %s, %s already exposes it", mapping.From, mapping.To, gs.Name)
+		}
+		exposed[mapping.To] = values[mapping.From]
+	}
+
+	if len(prefix) == 0 {
+		return exposed, nil
+	}
+
+	prefixed := make(map[string]string)
+	for key, value := range exposed {
+		prefixed[prefix+key] = value
+	}
+	return prefixed, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+type ImportDotenvCommand struct {
+	Name  string `short:"n" long:"name" description:"Name of environment variable." required:"yes"`
+	Shred bool   `long:"shred" description:"Overwrite and remove the file after importing."`
+	Args  struct {
+		File string `positional-arg-name:"FILE"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type ImportCommand struct {
+	Dotenv ImportDotenvCommand `command:"dotenv" description:"Import variables from a .env file."`
+}
+
+func (c *ImportDotenvCommand) Execute(args []string) error {
+	box, err := NewEnvBox()
+	if err != nil {
+		return errors.Wrap(err, "unable to create env box")
+	}
+
+	return box.ImportDotenv(c.Name, c.Args.File, c.Shred)
+}
+
+func init() {
+	var importCommand ImportCommand
+
+	_, err := parser.AddCommand("import", "Import environment variables.", "", &importCommand)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+}
@@ -10,7 +10,14 @@ type GenerateKeyCommand struct {
 	Set bool `short:"s" long:"set" description:"Set the new key as the one to use on this system."`
 }
 
-type SetKeyCommand struct{}
+type RotateKeyCommand struct {
+	Prompt     bool `short:"p" long:"prompt" description:"Prompt for the new key instead of generating one."`
+	Passphrase bool `long:"passphrase" description:"Derive the new key from a passphrase."`
+}
+
+type SetKeyCommand struct {
+	Passphrase bool `long:"passphrase" description:"Derive the key from a passphrase."`
+}
 
 type ShowKeyCommand struct{}
 
@@ -19,6 +26,7 @@ type ClearKeyCommand struct{}
 type KeyCommand struct {
 	Clear    ClearKeyCommand    `command:"clear" description:"Clear key."`
 	Generate GenerateKeyCommand `command:"generate" alias:"gen" description:"Generate new key."`
+	Rotate   RotateKeyCommand   `command:"rotate" description:"Re-encrypt all variables with a new key."`
 	Set      SetKeyCommand      `command:"set" description:"Set key."`
 	Show     ShowKeyCommand     `command:"show" description:"Show key."`
 }
@@ -41,13 +49,22 @@ func (r *GenerateKeyCommand) Execute(args []string) error {
 	return box.GenerateNewKey(r.Set)
 }
 
+func (r *RotateKeyCommand) Execute(args []string) error {
+	box, err := NewEnvBox()
+	if err != nil {
+		return errors.Wrap(err, "unable to create env box")
+	}
+
+	return box.RotateKey(r.Prompt, r.Passphrase)
+}
+
 func (r *SetKeyCommand) Execute(args []string) error {
 	box, err := NewEnvBox()
 	if err != nil {
 		return errors.Wrap(err, "unable to create env box")
 	}
 
-	return box.PromptAndStoreKey()
+	return box.PromptAndStoreKey(r.Passphrase)
 }
 
 func (r *ShowKeyCommand) Execute(args []string) error {
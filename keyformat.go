@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Keys are stored as 64 hex characters, which decode to the 32 bytes that
+// secretbox needs.  Early versions of envbox instead used the first 32
+// characters of the key string directly, so keys of at least that length are
+// still accepted and files sealed that way can still be opened.
+const (
+	keyLength       = 32
+	legacyKeyLength = 32
+	minPassphrase   = 8
+)
+
+// Key derivation identifiers, recorded in file headers so that files can be
+// opened without trying every derivation.
+const (
+	kdfHex    byte = 1
+	kdfLegacy byte = 2
+)
+
+// scrypt parameters for deriving a key from a passphrase.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+var errInvalidKey = fmt.Errorf("invalid key, expected %d hex characters", keyLength*2)
+
+// isHexKey reports whether key is in the current, hex encoded, format.
+func isHexKey(key string) bool {
+	decoded, err := hex.DecodeString(key)
+	return err == nil && len(decoded) == keyLength
+}
+
+// validateKey checks that a new key is in the current format.
+func validateKey(key string) error {
+	if !isHexKey(key) {
+		return errInvalidKey
+	}
+	return nil
+}
+
+// cipherKey is a secretbox key along with how it was derived from the key
+// that the user stores.
+type cipherKey struct {
+	kdf byte
+	key *[keyLength]byte
+}
+
+// id identifies the key in file headers without revealing it.
+func (ck cipherKey) id() []byte {
+	sum := sha256.Sum256(append([]byte("envbox key id\x00"), ck.key[:]...))
+	return sum[:keyIDLength]
+}
+
+// cipherKeys returns the secretbox keys that can be derived from key.  The
+// first one is the one to seal new data with.
+func cipherKeys(key string) ([]cipherKey, error) {
+	var keys []cipherKey
+
+	if isHexKey(key) {
+		var keyBytes [keyLength]byte
+		decoded, _ := hex.DecodeString(key)
+		copy(keyBytes[:], decoded)
+		keys = append(keys, cipherKey{kdf: kdfHex, key: &keyBytes})
+	}
+
+	if len(key) >= legacyKeyLength {
+		var keyBytes [keyLength]byte
+		copy(keyBytes[:], []byte(key)[:legacyKeyLength])
+		keys = append(keys, cipherKey{kdf: kdfLegacy, key: &keyBytes})
+	}
+
+	if len(keys) == 0 {
+		return nil, errInvalidKey
+	}
+
+	return keys, nil
+}
+
+// passphraseKey derives a key from a passphrase using scrypt.
+func passphraseKey(passphrase string, salt []byte) (string, error) {
+	if len(passphrase) < minPassphrase {
+		return "", fmt.Errorf("passphrase must be at least %d characters", minPassphrase)
+	}
+
+	derived, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keyLength)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to derive key")
+	}
+
+	return hex.EncodeToString(derived), nil
+}
+
+// readOrCreateSalt reads the salt used for passphrase keys, creating it if it
+// doesn't exist yet.  The salt isn't secret; it lives in the data directory so
+// that the same passphrase yields the same key wherever the data is copied.
+func readOrCreateSalt(saltPath string) ([]byte, error) {
+	salt, err := ioutil.ReadFile(saltPath)
+	if err == nil {
+		return salt, nil
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "unable to read salt")
+	}
+
+	salt = make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, errors.Wrap(err, "unable to read random")
+	}
+
+	if err := ioutil.WriteFile(saltPath, salt, 0600); err != nil {
+		return nil, errors.Wrap(err, "unable to write salt")
+	}
+
+	return salt, nil
+}
@@ -7,7 +7,14 @@ import (
 )
 
 type ListCommand struct {
-	// nothing
+	JSON      bool   `long:"json" description:"Print as JSON."`
+	NamesOnly bool   `long:"names-only" description:"Print only the names, one per line."`
+	Regex     bool   `short:"r" long:"regex" description:"Treat patterns as regular expressions instead of globs."`
+	Key       string `short:"k" long:"key" description:"Only list variables that expose a matching key."`
+	Tag       string `short:"t" long:"tag" description:"Only list variables with this tag."`
+	Args      struct {
+		Pattern string `positional-arg-name:"PATTERN" description:"Only list variables whose name or keys match."`
+	} `positional-args:"yes"`
 }
 
 var listCommand ListCommand
@@ -18,7 +25,14 @@ func (c *ListCommand) Execute(args []string) error {
 		return errors.Wrap(err, "unable to create env box")
 	}
 
-	return box.ListVariables()
+	filter := VarFilter{
+		Pattern: c.Args.Pattern,
+		Regex:   c.Regex,
+		Key:     c.Key,
+		Tag:     c.Tag,
+	}
+
+	return box.ListVariables(filter, c.JSON, c.NamesOnly)
 }
 
 func init() {
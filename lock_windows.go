@@ -0,0 +1,41 @@
+package main
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const errorSharingViolation syscall.Errno = 32
+
+// lockFile takes an exclusive lock on the file at path, creating it if needed
+// and blocking until the lock is available.  Windows has no advisory locks
+// in the syscall package, so the file is opened without sharing, which keeps
+// any other process from opening it until the handle is closed.
+func lockFile(path string) (func(), error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		handle, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+		if err == nil {
+			return func() {
+				syscall.CloseHandle(handle)
+			}, nil
+		}
+		if err != errorSharingViolation {
+			return nil, errors.Wrap(err, "unable to lock")
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// syncDir is a no-op on Windows, where directories can't be opened for
+// syncing.
+func syncDir(path string) error {
+	return nil
+}
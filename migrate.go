@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+type MigrateCommand struct {
+	DryRun bool `short:"n" long:"dry-run" description:"Only report which files would be rewritten."`
+}
+
+var migrateCommand MigrateCommand
+
+func (c *MigrateCommand) Execute(args []string) error {
+	box, err := NewEnvBox()
+	if err != nil {
+		return errors.Wrap(err, "unable to create env box")
+	}
+
+	return box.MigrateDataFiles(c.DryRun)
+}
+
+func init() {
+	_, err := parser.AddCommand("migrate", "Rewrite files stored in older formats.", "", &migrateCommand)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+}
@@ -13,14 +13,21 @@ import (
 type Prompter interface {
 	PromptMasked(string) (string, error)
 	PromptFor(string) (string, error)
+	PromptConfirmed(string, bool) (string, error)
 }
 
 type DefaultPrompter struct{}
 
 func (dp DefaultPrompter) PromptMasked(prompt string) (string, error) {
-	fmt.Printf(prompt)
+	// read from the terminal, so values can be masked even when stdin is
+	// redirected, falling back on stdin where there's no /dev/tty
+	in, out := os.Stdin, os.Stdout
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		defer tty.Close()
+		in, out = tty, tty
+	}
 
-	val, err := gopass.GetPasswdMasked()
+	val, err := gopass.GetPasswdPrompt(prompt, true, in, out)
 	if err != nil {
 		if err == gopass.ErrInterrupted {
 			return "", fmt.Errorf("interrupted")
@@ -51,3 +58,28 @@ func (db DefaultPrompter) PromptFor(prompt string) (string, error) {
 
 	return strings.TrimSpace(value), nil
 }
+
+// PromptConfirmed prompts for a value twice, failing if the two entries don't
+// match.
+func (dp DefaultPrompter) PromptConfirmed(prompt string, masked bool) (string, error) {
+	promptFunc := dp.PromptFor
+	if masked {
+		promptFunc = dp.PromptMasked
+	}
+
+	value, err := promptFunc(prompt)
+	if err != nil {
+		return "", err
+	}
+
+	again, err := promptFunc("confirm " + prompt)
+	if err != nil {
+		return "", err
+	}
+
+	if value != again {
+		return "", fmt.Errorf("values do not match")
+	}
+
+	return value, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	neturl "net/url"
+	"sort"
+)
+
+const (
+	redacted = "****"
+
+	// minRedactLength is the shortest value that's redacted.
+	minRedactLength = 4
+)
+
+// redactWriter replaces secrets in everything written through it with
+// asterisks.  A secret can be split across writes, so output that could be
+// the start of a secret is held back until it's known whether it is one;
+// Close writes out anything still held.
+type redactWriter struct {
+	w       io.Writer
+	secrets [][]byte
+	pending []byte
+}
+
+// newRedactWriter creates a redactWriter for the given secrets.
+func newRedactWriter(w io.Writer, secrets []string) *redactWriter {
+	rw := &redactWriter{w: w}
+	for _, secret := range secrets {
+		if len(secret) > 0 {
+			rw.secrets = append(rw.secrets, []byte(secret))
+		}
+	}
+
+	// check longer secrets first, so a secret containing another is
+	// redacted whole
+	sort.Slice(rw.secrets, func(i, j int) bool {
+		return len(rw.secrets[i]) > len(rw.secrets[j])
+	})
+
+	return rw
+}
+
+func (rw *redactWriter) Write(p []byte) (int, error) {
+	rw.pending = append(rw.pending, p...)
+	if err := rw.flush(false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes out any output held back because it looked like the start of
+// a secret.
+func (rw *redactWriter) Close() error {
+	return rw.flush(true)
+}
+
+// flush writes out pending output with secrets redacted.  Unless final is
+// set, output that could still turn out to be a secret is kept pending.
+func (rw *redactWriter) flush(final bool) error {
+	var out []byte
+	i := 0
+scan:
+	for i < len(rw.pending) {
+		rest := rw.pending[i:]
+
+		// secrets are longest first, so a longer secret that might still
+		// match wins over a shorter one that already does
+		for _, secret := range rw.secrets {
+			if bytes.HasPrefix(rest, secret) {
+				out = append(out, redacted...)
+				i += len(secret)
+				continue scan
+			}
+			if !final && bytes.HasPrefix(secret, rest) {
+				break scan
+			}
+		}
+
+		out = append(out, rw.pending[i])
+		i++
+	}
+
+	rw.pending = append(rw.pending[:0], rw.pending[i:]...)
+
+	_, err := rw.w.Write(out)
+	return err
+}
+
+// encodedForms returns the ways a secret commonly shows up encoded in
+// output, such as in Authorization headers or URLs.
+func encodedForms(secret string) []string {
+	forms := []string{
+		base64.StdEncoding.EncodeToString([]byte(secret)),
+		base64.RawStdEncoding.EncodeToString([]byte(secret)),
+		base64.URLEncoding.EncodeToString([]byte(secret)),
+		base64.RawURLEncoding.EncodeToString([]byte(secret)),
+		neturl.QueryEscape(secret),
+		neturl.PathEscape(secret),
+	}
+
+	var different []string
+	for _, form := range forms {
+		if form != secret {
+			different = append(different, form)
+		}
+	}
+	return different
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	secrets := []string{"hunter2", "hunter2hunter2", "s3cr3t"}
+	input := "password=hunter2 token=s3cr3t double=hunter2hunter2 hunter hunter2"
+	expected := "password=**** token=**** double=**** hunter ****"
+
+	// every split of the input across two writes gives the same output
+	for split := 0; split <= len(input); split++ {
+		var out bytes.Buffer
+		rw := newRedactWriter(&out, secrets)
+		rw.Write([]byte(input[:split]))
+		rw.Write([]byte(input[split:]))
+		assert.Nil(rw.Close())
+		assert.Equal(expected, out.String(), "split at %d", split)
+	}
+
+	// byte at a time
+	var out bytes.Buffer
+	rw := newRedactWriter(&out, secrets)
+	for i := range input {
+		rw.Write([]byte{input[i]})
+	}
+	rw.Close()
+	assert.Equal(expected, out.String())
+
+	// a partial secret at the end is written on close
+	out.Reset()
+	rw = newRedactWriter(&out, secrets)
+	rw.Write([]byte("ends with hunt"))
+	assert.Equal("ends with ", out.String())
+	rw.Close()
+	assert.Equal("ends with hunt", out.String())
+}
+
+func TestEncodedForms(t *testing.T) {
+	assert := assert.New(t)
+
+	forms := encodedForms("user:pa ss/word")
+	assert.Contains(forms, "dXNlcjpwYSBzcy93b3Jk")
+	assert.Contains(forms, "user%3Apa+ss%2Fword")
+	assert.NotContains(forms, "user:pa ss/word")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+type RenameCommand struct {
+	Name string `short:"n" long:"name" description:"Name of environment variable." required:"yes"`
+	Args struct {
+		NewName string `positional-arg-name:"NEW"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type RenameKeyCommand struct {
+	Name string `short:"n" long:"name" description:"Name of environment variable." required:"yes"`
+	Args struct {
+		Old string `positional-arg-name:"OLD"`
+		New string `positional-arg-name:"NEW"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var renameCommand RenameCommand
+var renameKeyCommand RenameKeyCommand
+
+func (c *RenameCommand) Execute(args []string) error {
+	box, err := NewEnvBox()
+	if err != nil {
+		return errors.Wrap(err, "unable to create env box")
+	}
+
+	return box.RenameVariable(c.Name, c.Args.NewName)
+}
+
+func (c *RenameKeyCommand) Execute(args []string) error {
+	box, err := NewEnvBox()
+	if err != nil {
+		return errors.Wrap(err, "unable to create env box")
+	}
+
+	return box.RenameKey(c.Name, c.Args.Old, c.Args.New)
+}
+
+func init() {
+	cmd, err := parser.AddCommand("rename", "Rename an environment variable.", "", &renameCommand)
+
+	cmd.Aliases = append(cmd.Aliases, "mv")
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	_, err = parser.AddCommand("rename-key", "Rename a key inside an environment variable.", "", &renameKeyCommand)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+}
@@ -7,7 +7,20 @@ import (
 )
 
 type RunCommand struct {
-	Vars []string `short:"e" long:"env" description:"Environment variables to expose" required:"yes"`
+	Vars          []string `short:"e" long:"env" value-name:"NAME[:NEW=STORED,...]" description:"Environment variables to expose, can be a glob, optionally exposing stored keys under new names."`
+	AsFiles       []string `long:"as-file" description:"Write this key to a temporary file and expose the file's path instead, can be repeated."`
+	Supervise     bool     `long:"supervise" description:"Run the command as a child process, forwarding signals, instead of replacing envbox."`
+	Redact        bool     `long:"redact" description:"Replace exposed values in the command's output with ****, implies --supervise."`
+	RedactEncoded bool     `long:"redact-encoded" description:"Like --redact, but also replace base64 and URL encoded forms of values."`
+	AllowMissing  bool     `long:"allow-missing" description:"Run the command even if some variables can't be found."`
+	Override      bool     `long:"override" description:"When variables expose the same name, let the later one win instead of failing."`
+	Clean         bool     `long:"clean" description:"Pass on only PATH, HOME and TERM from the current environment."`
+	Keep          []string `long:"keep" value-name:"PATTERN" description:"Also pass on variables matching this glob, implies --clean, can be repeated."`
+	Drop          []string `long:"drop" value-name:"PATTERN" description:"Don't pass on variables matching this glob, can be repeated."`
+	Prefix        string   `long:"prefix" description:"Add this prefix to the name of every exposed variable."`
+	Tags          []string `short:"t" long:"tag" description:"Expose variables with this tag, can be repeated."`
+	All           bool     `long:"all" description:"Expose all variables."`
+	DryRun        bool     `short:"n" long:"dry-run" description:"Print the names that would be exposed, without values, instead of running the command."`
 }
 
 var runCommand RunCommand
@@ -18,7 +31,25 @@ func (c *RunCommand) Execute(args []string) error {
 		return errors.Wrap(err, "unable to create env box")
 	}
 
-	return box.RunCommandWithEnv(c.Vars, args)
+	runOpts := RunOptions{
+		AsFiles:       c.AsFiles,
+		Supervise:     c.Supervise,
+		Redact:        c.Redact,
+		RedactEncoded: c.RedactEncoded,
+		AllowMissing:  c.AllowMissing,
+		Override:      c.Override,
+		HostEnv: EnvFilter{
+			Clean: c.Clean,
+			Keep:  c.Keep,
+			Drop:  c.Drop,
+		},
+		Prefix: c.Prefix,
+		Tags:   c.Tags,
+		All:    c.All,
+		DryRun: c.DryRun,
+	}
+
+	return box.RunCommandWithEnv(c.Vars, runOpts, args)
 }
 
 func init() {
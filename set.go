@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+type SetCommand struct {
+	Name    string   `short:"n" long:"name" description:"Name of environment variable." required:"yes"`
+	File    string   `short:"f" long:"file" description:"File with contents of variable"`
+	Stdin   bool     `long:"stdin" description:"Read the value from stdin."`
+	FromEnv string   `long:"value-from-env" description:"Take the value from this environment variable."`
+	Exposed string   `short:"e" long:"exposed" description:"Key to change, or add if it doesn't exist; needed when the variable has several keys."`
+	Echo    bool     `long:"echo" description:"Show the value as it is typed."`
+	Confirm bool     `short:"c" long:"confirm" description:"Enter the value twice to confirm it."`
+	Raw     bool     `long:"raw" description:"Store the value exactly, without trimming whitespace."`
+	Desc    string   `short:"d" long:"description" description:"Describe what the variable is for."`
+	Tags    []string `short:"t" long:"tag" description:"Add a tag, can be repeated."`
+	Untag   []string `long:"untag" description:"Remove a tag, can be repeated."`
+}
+
+var setCommand SetCommand
+
+func (c *SetCommand) Execute(args []string) error {
+	box, err := NewEnvBox()
+	if err != nil {
+		return errors.Wrap(err, "unable to create env box")
+	}
+
+	valueOpts := ValueOptions{
+		File:    c.File,
+		Stdin:   c.Stdin,
+		FromEnv: c.FromEnv,
+		Echo:    c.Echo,
+		Confirm: c.Confirm,
+		Raw:     c.Raw,
+	}
+
+	meta := VarMetadata{
+		Description: c.Desc,
+		Tags:        c.Tags,
+		Untag:       c.Untag,
+	}
+
+	return box.SetVariable(c.Name, c.Exposed, valueOpts, meta)
+}
+
+func init() {
+	cmd, err := parser.AddCommand("set", "Change a value in an existing environment variable.", "", &setCommand)
+
+	cmd.Aliases = append(cmd.Aliases, "update")
+
+	if err != nil {
+		fmt.Println(err)
+	}
+}
@@ -9,6 +9,8 @@ import (
 type ShowCommand struct {
 	Name   string `short:"n" long:"name" description:"Name of environment variable." required:"yes"`
 	Export bool   `short:"e" long:"export" description:"Instead of human readable, format for shell eval"`
+	JSON   bool   `long:"json" description:"Print as JSON."`
+	Format string `short:"f" long:"format" description:"Instead of human readable, use this format" choice:"sh" choice:"fish" choice:"powershell" choice:"dotenv" choice:"json" choice:"yaml" choice:"docker" choice:"systemd"`
 }
 
 var showCommand ShowCommand
@@ -19,10 +21,13 @@ func (c *ShowCommand) Execute(args []string) error {
 		return errors.Wrap(err, "unable to create env box")
 	}
 
+	if len(c.Format) > 0 {
+		return box.ExportVariable(c.Name, c.Format)
+	}
 	if c.Export {
-		return box.ExportVariable(c.Name)
+		return box.ExportVariable(c.Name, "sh")
 	}
-	return box.ShowVariable(c.Name)
+	return box.ShowVariable(c.Name, c.JSON)
 }
 
 func init() {
@@ -0,0 +1,131 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// forwardedSignals are passed on to supervised commands.
+var forwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGWINCH}
+
+// runSupervised starts cmd in its own process group and waits for it,
+// sending it each of signals.  Signals from the terminal go to the command's
+// group and not envbox's, so every signal envbox receives was meant for it
+// alone and is passed on exactly once.  If envbox is in the foreground of its
+// terminal, the command is given the terminal until it exits.
+func runSupervised(cmd *exec.Cmd, signals <-chan os.Signal) (syscall.WaitStatus, error) {
+	tty := foregroundTerminal()
+	if tty != nil {
+		defer tty.Close()
+		cmd.SysProcAttr = &syscall.SysProcAttr{Foreground: true, Ctty: int(tty.Fd())}
+	} else {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	defer cmd.Process.Release()
+	pid := cmd.Process.Pid
+	if tty != nil {
+		defer setForeground(tty, syscall.Getpgrp())
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				syscall.Kill(-pid, sig.(syscall.Signal))
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		var ws syscall.WaitStatus
+		if _, err := syscall.Wait4(pid, &ws, syscall.WUNTRACED, nil); err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return 0, err
+		}
+		if !ws.Stopped() {
+			return ws, nil
+		}
+
+		// the command was suspended, with Ctrl-Z for example, so envbox is
+		// suspended too, so the shell notices, and the command is resumed
+		// when envbox is
+		if tty != nil {
+			setForeground(tty, syscall.Getpgrp())
+		}
+		suspend(ws.StopSignal())
+		if tty != nil && foregroundGroup(tty) == syscall.Getpgrp() {
+			setForeground(tty, pid)
+		}
+		syscall.Kill(-pid, syscall.SIGCONT)
+	}
+}
+
+// suspend stops envbox with sig, returning once it's continued.
+func suspend(sig syscall.Signal) {
+	continued := make(chan os.Signal, 1)
+	signal.Notify(continued, syscall.SIGCONT)
+	defer signal.Stop(continued)
+
+	syscall.Kill(os.Getpid(), sig)
+	<-continued
+}
+
+// foregroundTerminal returns envbox's terminal if its process group is in the
+// foreground there, or nil.
+func foregroundTerminal() *os.File {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return nil
+	}
+	if foregroundGroup(tty) != syscall.Getpgrp() {
+		tty.Close()
+		return nil
+	}
+	return tty
+}
+
+// foregroundGroup returns the foreground process group of tty, or -1 if it
+// can't be found.
+func foregroundGroup(tty *os.File) int {
+	var pgrp int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgrp))); errno != 0 {
+		return -1
+	}
+	return int(pgrp)
+}
+
+// setForeground puts pgrp in the foreground of tty.  SIGTTOU is ignored
+// meanwhile, since it's sent to background groups that try.
+func setForeground(tty *os.File, pgrp int) {
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+
+	p := int32(pgrp)
+	syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&p)))
+}
+
+// raiseSignal sends sig to envbox itself with its default handling restored,
+// which normally terminates the process.
+func raiseSignal(sig syscall.Signal) {
+	signal.Reset(sig)
+	syscall.Kill(os.Getpid(), sig)
+
+	// give the signal a moment to be delivered, in case it isn't fatal
+	time.Sleep(100 * time.Millisecond)
+}
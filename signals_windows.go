@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// forwardedSignals are passed on to supervised commands.
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+// runSupervised starts cmd and waits for it, sending it each of signals.
+func runSupervised(cmd *exec.Cmd, signals <-chan os.Signal) (syscall.WaitStatus, error) {
+	if err := cmd.Start(); err != nil {
+		return syscall.WaitStatus{}, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				cmd.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return syscall.WaitStatus{}, err
+		}
+	}
+	return cmd.ProcessState.Sys().(syscall.WaitStatus), nil
+}
+
+// raiseSignal does nothing on Windows, where processes aren't killed by
+// signals; the exit code is used instead.
+func raiseSignal(sig syscall.Signal) {}
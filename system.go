@@ -2,18 +2,34 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"syscall"
 )
 
 type System interface {
 	Getenv(string) string
+	Environ() []string
 	FileExists(string) bool
 	DataPath() (string, error)
 	ExecCommandWithEnv(string, []string, []string) error
+	SuperviseCommandWithEnv(string, []string, []string, io.Writer, io.Writer) (ExitStatus, error)
+	Exit(ExitStatus)
+	GetCredHelperKey() (string, error)
+	StoreCredHelperKey(string) error
+	ClearCredHelperKey() error
+}
+
+// ExitStatus is how a supervised command finished: either with an exit code
+// or, if Signal is set, killed by a signal.
+type ExitStatus struct {
+	Code   int
+	Signal syscall.Signal
 }
 
 type DefaultSystem struct{}
@@ -22,6 +38,22 @@ func (ds DefaultSystem) Getenv(key string) string {
 	return os.Getenv(key)
 }
 
+func (ds DefaultSystem) Environ() []string {
+	return os.Environ()
+}
+
+func (ds DefaultSystem) GetCredHelperKey() (string, error) {
+	return GetCredHelperKey()
+}
+
+func (ds DefaultSystem) StoreCredHelperKey(key string) error {
+	return StoreCredHelperKey(key)
+}
+
+func (ds DefaultSystem) ClearCredHelperKey() error {
+	return ClearCredHelperKey()
+}
+
 func (ds DefaultSystem) FileExists(localPath string) bool {
 	if _, err := os.Stat(localPath); os.IsNotExist(err) {
 		return false
@@ -47,19 +79,12 @@ func (ds DefaultSystem) DataPath() (string, error) {
 
 func (ds DefaultSystem) ExecCommandWithEnv(command string, args []string, extraEnv []string) error {
 	if runtime.GOOS == "windows" {
-		cmd := exec.Command(command, args...)
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Env = extraEnv
-
-		if err := cmd.Run(); err != nil {
-			if eerr, ok := err.(*exec.ExitError); ok {
-				os.Exit(eerr.Sys().(syscall.WaitStatus).ExitStatus())
-			}
+		status, err := ds.SuperviseCommandWithEnv(command, args, extraEnv, os.Stdout, os.Stderr)
+		if err != nil {
+			return err
 		}
 
-		os.Exit(0)
+		ds.Exit(status)
 		return nil
 	} else {
 		// adapted from https://gobyexample.com/execing-processes
@@ -70,6 +95,74 @@ func (ds DefaultSystem) ExecCommandWithEnv(command string, args []string, extraE
 		return syscall.Exec(fullPath, append([]string{filepath.Base(command)}, args...), extraEnv)
 		// end adapted from
 	}
+}
+
+// SuperviseCommandWithEnv runs a command as a child process, forwarding
+// signals to it, and returns how it finished.  Unlike ExecCommandWithEnv,
+// this returns control to envbox afterwards, so it can clean up.  The
+// command's output goes to stdout and stderr, and all of it has been written
+// by the time this returns.
+func (ds DefaultSystem) SuperviseCommandWithEnv(command string, args []string, extraEnv []string, stdout, stderr io.Writer) (ExitStatus, error) {
+	cmd := exec.Command(command, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Env = extraEnv
+
+	// output that isn't to a file is copied here rather than by exec, which
+	// only waits for its copying when it waits for the command itself
+	var pipes []*os.File
+	var copying sync.WaitGroup
+	defer copying.Wait()
+	defer func() {
+		for _, pipe := range pipes {
+			pipe.Close()
+		}
+	}()
+	output := func(w io.Writer) (*os.File, error) {
+		if f, ok := w.(*os.File); ok {
+			return f, nil
+		}
+		r, pw, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		pipes = append(pipes, pw)
+		copying.Add(1)
+		go func() {
+			defer copying.Done()
+			defer r.Close()
+			io.Copy(w, r)
+		}()
+		return pw, nil
+	}
+	var err error
+	if cmd.Stdout, err = output(stdout); err != nil {
+		return ExitStatus{}, err
+	}
+	if cmd.Stderr, err = output(stderr); err != nil {
+		return ExitStatus{}, err
+	}
+
+	// start listening before the child starts, so no signal is missed
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
 
-	return nil
+	ws, err := runSupervised(cmd, signals)
+	if err != nil {
+		return ExitStatus{}, err
+	}
+	if ws.Signaled() {
+		return ExitStatus{Code: 128 + int(ws.Signal()), Signal: ws.Signal()}, nil
+	}
+	return ExitStatus{Code: ws.ExitStatus()}, nil
+}
+
+// Exit exits envbox the same way a supervised command did.  If it was killed
+// by a signal, the same signal is raised again so the parent sees it, with
+// the shell convention of 128 plus the signal number as a fallback.
+func (ds DefaultSystem) Exit(status ExitStatus) {
+	if status.Signal != 0 {
+		raiseSignal(status.Signal)
+	}
+	os.Exit(status.Code)
 }
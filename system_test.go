@@ -0,0 +1,88 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuperviseCommandWithEnv(t *testing.T) {
+	assert := assert.New(t)
+
+	ds := DefaultSystem{}
+
+	status, err := ds.SuperviseCommandWithEnv("sh", []string{"-c", "exit 7"}, nil, os.Stdout, os.Stderr)
+	assert.Nil(err)
+	assert.Equal(ExitStatus{Code: 7}, status)
+
+	status, err = ds.SuperviseCommandWithEnv("sh", []string{"-c", "kill -TERM $$"}, nil, os.Stdout, os.Stderr)
+	assert.Nil(err)
+	assert.Equal(ExitStatus{Code: 128 + int(syscall.SIGTERM), Signal: syscall.SIGTERM}, status)
+
+	// a signal sent to envbox is forwarded to the child
+	status, err = ds.SuperviseCommandWithEnv("sh", []string{"-c", `trap 'kill $!; exit 5' HUP; sleep 5 & kill -HUP $PPID; wait`}, nil, os.Stdout, os.Stderr)
+	assert.Nil(err)
+	assert.Equal(ExitStatus{Code: 5}, status)
+
+	// output to anything but a file is all copied before returning
+	var out, errOut bytes.Buffer
+	status, err = ds.SuperviseCommandWithEnv("sh", []string{"-c", "echo out; echo err >&2"}, nil, &out, &errOut)
+	assert.Nil(err)
+	assert.Equal(ExitStatus{}, status)
+	assert.Equal("out\n", out.String())
+	assert.Equal("err\n", errOut.String())
+
+	_, err = ds.SuperviseCommandWithEnv("envbox-command-that-does-not-exist", nil, nil, os.Stdout, os.Stderr)
+	assert.NotNil(err)
+}
+
+// TestSuperviseForegroundHelper supervises a command for
+// TestSuperviseForegroundSignals, which runs it in a separate process group.
+// The command exits with the number of SIGINTs it received.
+func TestSuperviseForegroundHelper(t *testing.T) {
+	if os.Getenv("ENVBOX_FOREGROUND_HELPER") != "1" {
+		return
+	}
+
+	script := `n=0; trap 'n=$((n+1))' INT; echo ready; i=0; while [ $i -lt 10 ]; do sleep 0.1 & wait; i=$((i+1)); done; exit $n`
+	status, err := DefaultSystem{}.SuperviseCommandWithEnv("sh", []string{"-c", script}, nil, os.Stdout, os.Stderr)
+	if err != nil {
+		os.Exit(100)
+	}
+	os.Exit(status.Code)
+}
+
+func TestSuperviseForegroundSignals(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSuperviseForegroundHelper$")
+	cmd.Env = append(os.Environ(), "ENVBOX_FOREGROUND_HELPER=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdout, err := cmd.StdoutPipe()
+	assert.Nil(err)
+	assert.Nil(cmd.Start())
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	assert.Nil(err)
+	assert.Equal("ready\n", line)
+
+	// sent to envbox's whole group, which the command isn't in, and then to
+	// envbox alone; the command gets each of them once
+	assert.Nil(syscall.Kill(-cmd.Process.Pid, syscall.SIGINT))
+	time.Sleep(300 * time.Millisecond)
+	assert.Nil(syscall.Kill(cmd.Process.Pid, syscall.SIGINT))
+
+	err = cmd.Wait()
+	assert.NotNil(err)
+	if eerr, ok := err.(*exec.ExitError); ok {
+		assert.Equal(2, eerr.ExitCode())
+	}
+}
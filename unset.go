@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+type UnsetCommand struct {
+	Name    string   `short:"n" long:"name" description:"Name of environment variable." required:"yes"`
+	Exposed []string `short:"k" long:"key" description:"Exposed variable to remove, can be repeated." required:"yes"`
+}
+
+var unsetCommand UnsetCommand
+
+func (c *UnsetCommand) Execute(args []string) error {
+	box, err := NewEnvBox()
+	if err != nil {
+		return errors.Wrap(err, "unable to create env box")
+	}
+
+	return box.UnsetVariable(c.Name, c.Exposed)
+}
+
+func init() {
+	_, err := parser.AddCommand("unset", "Remove keys from an environment variable.", "", &unsetCommand)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+}